@@ -0,0 +1,717 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/honeycombio/beeline-go"
+)
+
+const (
+	calendarProductID = "-//go-discord-bot//remindme//EN"
+	calendarTimestamp = "20060102T150405"
+	calendarDate      = "20060102"
+	// maxCalendarSize limits how much of an uploaded .ics file is read
+	maxCalendarSize = 1 << 20
+)
+
+// reminderCalendar writes reminders as an iCalendar file, with recurring reminders
+// repeating by an RRULE in their own timezone, which is described by a VTIMEZONE
+func reminderCalendar(reminders []Reminder, now time.Time) string {
+	var b strings.Builder
+	line := func(l string) {
+		b.WriteString(foldCalendarLine(l))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:" + calendarProductID)
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:Reminders")
+
+	// every TZID used needs a VTIMEZONE, covering the reminders from the first in each zone
+	zones := make(map[string]time.Time)
+	var names []string
+	latest := now
+	for _, r := range reminders {
+		if r.Due.After(latest) {
+			latest = r.Due
+		}
+		if r.Recurrence == nil || r.Recurrence.location() == time.UTC {
+			continue
+		}
+		zone := r.Recurrence.location().String()
+		if first, ok := zones[zone]; !ok || r.Due.Before(first) {
+			if !ok {
+				names = append(names, zone)
+			}
+			zones[zone] = r.Due
+		}
+	}
+	sort.Strings(names)
+	for _, zone := range names {
+		loc, _ := time.LoadLocation(zone)
+		calendarTimezone(line, loc, zones[zone].Add(-24*time.Hour), latest.AddDate(calendarTimezoneYears, 0, 0))
+	}
+
+	for _, r := range reminders {
+		line("BEGIN:VEVENT")
+		line("UID:" + r.ID + "@remindme")
+		line("DTSTAMP:" + now.UTC().Format(calendarTimestamp) + "Z")
+		if r.Recurrence != nil && r.Recurrence.location() != time.UTC {
+			line("DTSTART;TZID=" + r.Recurrence.location().String() + ":" + r.Due.In(r.Recurrence.location()).Format(calendarTimestamp))
+		} else {
+			line("DTSTART:" + r.Due.UTC().Format(calendarTimestamp) + "Z")
+		}
+		line("SUMMARY:" + escapeCalendarText(r.Message))
+		if r.SourceMessage != "" {
+			line("URL:" + messageLink(r.Server, r.Channel, r.SourceMessage))
+		}
+		if r.Recurrence != nil {
+			line("RRULE:" + recurrenceRule(*r.Recurrence))
+		}
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+// calendarTimezoneYears is how far past the last reminder a VTIMEZONE lists offset changes
+const calendarTimezoneYears = 10
+
+// calendarTimezone writes a VTIMEZONE for loc, with an observance for its offset at start
+// and one for each change of UTC offset from then until end, taken from Go's timezone data
+func calendarTimezone(line func(string), loc *time.Location, start time.Time, end time.Time) {
+	line("BEGIN:VTIMEZONE")
+	line("TZID:" + loc.String())
+
+	observance := func(at time.Time, from int) {
+		local := at.In(loc)
+		name, offset := local.Zone()
+		kind := "STANDARD"
+		if local.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		line("BEGIN:" + kind)
+		// the onset is given in the local time before the change
+		line("DTSTART:" + at.UTC().Add(time.Duration(from)*time.Second).Format(calendarTimestamp))
+		line("TZOFFSETFROM:" + calendarOffset(from))
+		line("TZOFFSETTO:" + calendarOffset(offset))
+		line("TZNAME:" + escapeCalendarText(name))
+		line("END:" + kind)
+	}
+
+	start = start.Truncate(time.Second)
+	_, offset := start.In(loc).Zone()
+	observance(start, offset)
+	for _, change := range offsetChanges(loc, start, end) {
+		observance(change, offset)
+		_, offset = change.In(loc).Zone()
+	}
+
+	line("END:VTIMEZONE")
+}
+
+// offsetChanges returns the instants loc changes its UTC offset between start and end
+func offsetChanges(loc *time.Location, start time.Time, end time.Time) []time.Time {
+	offsetAt := func(unix int64) int {
+		_, offset := time.Unix(unix, 0).In(loc).Zone()
+		return offset
+	}
+
+	var changes []time.Time
+	offset := offsetAt(start.Unix())
+	for day := start.Unix(); day < end.Unix(); day += 24 * 60 * 60 {
+		next := day + 24*60*60
+		if offsetAt(next) == offset {
+			continue
+		}
+
+		// narrow it down to the second
+		lo, hi := day, next
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if offsetAt(mid) == offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		changes = append(changes, time.Unix(hi, 0))
+		offset = offsetAt(hi)
+	}
+	return changes
+}
+
+// calendarOffset writes a UTC offset in seconds like +0100 or -0330
+func calendarOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	offset := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		offset += fmt.Sprintf("%02d", seconds%60)
+	}
+	return offset
+}
+
+var calendarFrequencies = map[string]string{
+	recurMinute: "MINUTELY",
+	recurHour:   "HOURLY",
+	recurDay:    "DAILY",
+	recurWeek:   "WEEKLY",
+	recurMonth:  "MONTHLY",
+}
+
+// recurrenceRule writes a recurrence as an RRULE value
+func recurrenceRule(rec Recurrence) string {
+	var parts []string
+
+	switch {
+	case len(rec.Weekdays) > 0:
+		var days []string
+		for _, d := range rec.Weekdays {
+			days = append(days, strings.ToUpper(d.String()[:2]))
+		}
+		parts = append(parts, "FREQ=WEEKLY", "BYDAY="+strings.Join(days, ","))
+	case rec.MonthDay > 0:
+		parts = append(parts, "FREQ=MONTHLY")
+		if rec.Every > 1 {
+			parts = append(parts, "INTERVAL="+strconv.Itoa(rec.Every))
+		}
+		if rec.MonthDay <= 28 {
+			parts = append(parts, "BYMONTHDAY="+strconv.Itoa(rec.MonthDay))
+		} else {
+			// the last day of the month up to MonthDay, as next clamps short months
+			var days []string
+			for d := 28; d <= rec.MonthDay; d++ {
+				days = append(days, strconv.Itoa(d))
+			}
+			parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","), "BYSETPOS=-1")
+		}
+	default:
+		parts = append(parts, "FREQ="+calendarFrequencies[rec.Unit])
+		if rec.Every > 1 {
+			parts = append(parts, "INTERVAL="+strconv.Itoa(rec.Every))
+		}
+	}
+
+	if !rec.Until.IsZero() {
+		parts = append(parts, "UNTIL="+rec.Until.UTC().Format(calendarTimestamp)+"Z")
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// foldCalendarLine splits lines longer than 75 octets, without splitting a character
+func foldCalendarLine(l string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range l {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
+
+var calendarEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+var calendarUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func escapeCalendarText(text string) string {
+	return calendarEscaper.Replace(strings.TrimSpace(text))
+}
+
+// calendarEvent is a VEVENT read from an uploaded calendar
+type calendarEvent struct {
+	summary    string
+	start      time.Time
+	recurrence *Recurrence
+}
+
+// parseCalendar reads the events from an iCalendar file. Times without a timezone are
+// read in loc, and all-day events are taken to start at the default time of day.
+func parseCalendar(data string, loc *time.Location) ([]calendarEvent, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+
+	var events []calendarEvent
+	var event *calendarEvent
+	var rule string
+	nested := 0
+
+	for _, l := range strings.Split(data, "\n") {
+		name, params, value, ok := parseCalendarLine(l)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event, rule, nested = &calendarEvent{}, "", 0
+		case event == nil:
+		case name == "BEGIN":
+			// alarms and other components inside the event have their own properties
+			nested++
+		case name == "END" && nested > 0:
+			nested--
+		case nested > 0:
+		case name == "END" && value == "VEVENT":
+			if event.start.IsZero() {
+				return nil, fmt.Errorf("The event %q doesn't have a start time", event.summary)
+			}
+			if rule != "" {
+				rec, err := parseRecurrenceRule(rule, event.start)
+				if err != nil {
+					return nil, fmt.Errorf("The event %q: %w", event.summary, err)
+				}
+				event.recurrence = &rec
+			}
+			events = append(events, *event)
+			event = nil
+		case name == "SUMMARY":
+			event.summary = calendarUnescaper.Replace(value)
+		case name == "DTSTART":
+			start, err := parseCalendarTime(value, params, loc)
+			if err != nil {
+				return nil, err
+			}
+			event.start = start
+		case name == "RRULE":
+			rule = value
+		}
+	}
+
+	return events, nil
+}
+
+// parseCalendarLine splits a content line into its upper-cased name, parameters and value
+func parseCalendarLine(l string) (string, map[string]string, string, bool) {
+	colon := strings.Index(l, ":")
+	if colon < 0 {
+		return "", nil, "", false
+	}
+
+	fields := strings.Split(l[:colon], ";")
+	params := make(map[string]string)
+	for _, p := range fields[1:] {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(fields[0]), params, strings.TrimSpace(l[colon+1:]), true
+}
+
+// parseCalendarTime reads a DATE or DATE-TIME value, in UTC, its TZID or loc
+func parseCalendarTime(value string, params map[string]string, loc *time.Location) (time.Time, error) {
+	if tzid, ok := params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	if params["VALUE"] == "DATE" || len(value) == len(calendarDate) {
+		day, err := time.ParseInLocation(calendarDate, value, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s isn't a date", value)
+		}
+		return atClock(day, "")
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(calendarTimestamp, strings.TrimSuffix(value, "Z"), loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s isn't a time", value)
+	}
+	return t, nil
+}
+
+var calendarWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// parseRecurrenceRule reads the RRULEs reminders can repeat by, those repeating every
+// so many units, on days of the week or on a day of the month
+func parseRecurrenceRule(rule string, start time.Time) (Recurrence, error) {
+	parts := make(map[string]string)
+	for _, p := range strings.Split(rule, ";") {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			parts[strings.ToUpper(kv[0])] = strings.ToUpper(kv[1])
+		}
+	}
+
+	rec := Recurrence{Every: 1, Timezone: start.Location().String()}
+	if interval, ok := parts["INTERVAL"]; ok {
+		n, err := strconv.Atoi(interval)
+		if err != nil || n < 1 {
+			return Recurrence{}, fmt.Errorf("%s isn't a repeat interval", interval)
+		}
+		rec.Every = n
+	}
+
+	switch parts["FREQ"] {
+	case "MINUTELY":
+		rec.Unit = recurMinute
+	case "HOURLY":
+		rec.Unit = recurHour
+	case "DAILY":
+		rec.Unit = recurDay
+	case "WEEKLY":
+		rec.Unit = recurWeek
+	case "MONTHLY":
+		rec.Unit = recurMonth
+	case "YEARLY":
+		rec.Unit = recurMonth
+		rec.Every *= 12
+	default:
+		return Recurrence{}, fmt.Errorf("reminders can't repeat %s", strings.ToLower(parts["FREQ"]))
+	}
+
+	if days, ok := parts["BYDAY"]; ok {
+		if rec.Unit != recurWeek || rec.Every > 1 {
+			return Recurrence{}, fmt.Errorf("reminders on a weekday can only repeat every week")
+		}
+		for _, d := range strings.Split(days, ",") {
+			day, ok := calendarWeekdays[d]
+			if !ok {
+				return Recurrence{}, fmt.Errorf("reminders can't repeat on %s", d)
+			}
+			rec.Weekdays = append(rec.Weekdays, day)
+		}
+		rec.Unit = recurDay
+	}
+
+	if day, ok := parts["BYMONTHDAY"]; ok {
+		n, err := strconv.Atoi(day)
+		if err != nil || n < 1 || n > 31 || rec.Unit != recurMonth {
+			return Recurrence{}, fmt.Errorf("reminders can't repeat on day %s of the month", day)
+		}
+		rec.MonthDay = n
+	}
+
+	if rec.Unit != recurMinute && rec.Unit != recurHour {
+		rec.At = start.Format("15:04")
+	}
+
+	if until, ok := parts["UNTIL"]; ok {
+		end, err := parseCalendarTime(until, nil, start.Location())
+		if err != nil {
+			return Recurrence{}, err
+		}
+		if len(until) == len(calendarDate) {
+			end = time.Date(end.Year(), end.Month(), end.Day(), 23, 59, 59, 0, end.Location())
+		}
+		rec.Until = end
+	}
+
+	if count, ok := parts["COUNT"]; ok {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return Recurrence{}, fmt.Errorf("%s isn't a repeat count", count)
+		}
+		// the series ends on its nth occurrence, counting the start
+		last := start
+		for i := 1; i < n; i++ {
+			next, ok := rec.next(last)
+			if !ok {
+				break
+			}
+			last = next
+		}
+		rec.Until = last
+	}
+
+	return rec, nil
+}
+
+// exportReminders builds a DM with the user's pending reminders as an .ics file, and the
+// address of their calendar feed if it's enabled
+func exportReminders(ctx context.Context, store ReminderStore, settings UserSettingsStore, userID string, reset bool, now time.Time) (*discordgo.MessageSend, error) {
+	ctx, span := beeline.StartSpan(ctx, "exportReminders")
+	defer span.Send()
+
+	res, err := store.ListByCreator(ctx, userID, now)
+	if err != nil {
+		span.AddField("exportReminders.error", err)
+		return nil, err
+	}
+
+	var reminders []Reminder
+	for _, r := range res {
+		if r.Status == reminderPending || r.Status == "" {
+			reminders = append(reminders, r)
+		}
+	}
+	span.AddField("exportReminders.count", len(reminders))
+
+	content := fmt.Sprintf("Here are your %d pending reminders.", len(reminders))
+	if feed := calendarFeedURL(); feed != "" {
+		token, err := calendarToken(ctx, settings, userID, reset)
+		if err != nil {
+			span.AddField("exportReminders.error", err)
+			return nil, err
+		}
+		content += fmt.Sprintf(" Subscribe to %s/calendar/%s.ics to keep your calendar up to date, and keep the link secret. `!remindme export reset` gives you a new one, and `!remindme export revoke` turns it off.", feed, token)
+	}
+
+	return &discordgo.MessageSend{
+		Content: content,
+		Files: []*discordgo.File{{
+			Name:        "reminders.ics",
+			ContentType: "text/calendar",
+			Reader:      strings.NewReader(reminderCalendar(reminders, now)),
+		}},
+	}, nil
+}
+
+// calendarFeedURL is where the calendar feed can be reached, REMINDER_FEED_URL, or empty
+// if the feed is disabled
+func calendarFeedURL() string {
+	return strings.TrimSuffix(envOrDefault("REMINDER_FEED_URL", ""), "/")
+}
+
+// calendarToken returns the secret in the user's feed address, creating one the first
+// time or when reset
+func calendarToken(ctx context.Context, settings UserSettingsStore, userID string, reset bool) (string, error) {
+	s, err := settings.Get(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if s.CalendarToken != "" && !reset {
+		return s.CalendarToken, nil
+	}
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s.CalendarToken = hex.EncodeToString(b)
+
+	if err := settings.Save(ctx, s); err != nil {
+		return "", err
+	}
+	return s.CalendarToken, nil
+}
+
+// revokeCalendarFeed removes the user's calendar token, so their feed address stops working
+func revokeCalendarFeed(ctx context.Context, settings UserSettingsStore, userID string) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "revokeCalendarFeed")
+	defer span.Send()
+
+	if err := settings.RevokeCalendarToken(ctx, userID); err != nil {
+		span.AddField("revokeCalendarFeed.error", err)
+		return "", err
+	}
+	return "Your calendar feed link no longer works. `!remindme export` gives you a new one.", nil
+}
+
+// importReminders creates a reminder for each event in an uploaded calendar. Events that
+// have already happened are skipped, and recurring ones start from their next occurrence.
+func importReminders(ctx context.Context, store ReminderStore, scheduler *reminderScheduler, message *discordgo.Message, data string, opts reminderOptions) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "importReminders")
+	defer span.Send()
+
+	if opts.loc == nil {
+		opts.loc = time.UTC
+	}
+
+	events, err := parseCalendar(data, opts.loc)
+	if err != nil {
+		span.AddField("importReminders.error", err)
+		return "", err
+	}
+	span.AddField("importReminders.events", len(events))
+
+	var target, targetChannel string
+	if opts.settings != nil {
+		settings, err := opts.settings.Get(ctx, message.Author.ID)
+		if err != nil {
+			span.AddField("importReminders.error", err)
+			return "", err
+		}
+		target, targetChannel = settings.DefaultTarget, settings.DefaultTargetChannel
+	}
+	if message.GuildID == "" {
+		target = reminderTargetDM
+	}
+
+	now := message.Timestamp
+	imported, skipped := 0, 0
+	for _, event := range events {
+		due := event.start
+		if event.recurrence != nil && !due.After(now) {
+			next, ok := event.recurrence.nextAfter(due, now)
+			if !ok {
+				skipped++
+				continue
+			}
+			due = next
+		} else if !due.After(now) {
+			skipped++
+			continue
+		}
+
+		summary := strings.TrimSpace(event.summary)
+		if summary == "" {
+			summary = "calendar event"
+		}
+
+		r := Reminder{
+			Due:             due,
+			Message:         summary,
+			Server:          message.GuildID,
+			Creator:         message.Author.ID,
+			Channel:         message.ChannelID,
+			Target:          target,
+			TargetChannel:   targetChannel,
+			SourceMessage:   message.ID,
+			SourceTimestamp: message.Timestamp,
+			BotSource:       reminderBotSource,
+			Recurrence:      event.recurrence,
+		}
+
+		if err := opts.limits.check(ctx, store, r, now); err != nil {
+			span.AddField("importReminders.limit", err)
+			return fmt.Sprintf("Imported %d reminders before stopping: %s", imported, err), nil
+		}
+
+		r, err = storeReminder(ctx, store, r)
+		if err != nil {
+			span.AddField("importReminders.error", err)
+			return "", err
+		}
+		scheduler.Schedule(r)
+		imported++
+	}
+
+	span.AddField("importReminders.imported", imported)
+	span.AddField("importReminders.skipped", skipped)
+
+	resp := fmt.Sprintf("Imported %d reminders.", imported)
+	if skipped > 0 {
+		resp += fmt.Sprintf(" Skipped %d events that have already happened.", skipped)
+	}
+	return resp, nil
+}
+
+// downloadCalendar fetches an uploaded .ics attachment
+func downloadCalendar(ctx context.Context, attachment *discordgo.MessageAttachment) (string, error) {
+	if !strings.HasSuffix(strings.ToLower(attachment.Filename), ".ics") {
+		return "", fmt.Errorf("Attach an .ics calendar file to import")
+	}
+	if attachment.Size > maxCalendarSize {
+		return "", fmt.Errorf("That calendar is too big to import")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Couldn't download the calendar: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCalendarSize))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// calendarFeed serves each user's reminders at /calendar/<token>.ics, so calendar apps
+// can subscribe to them. Reminders from the last 30 days are kept so they don't vanish
+// from the calendar as soon as they're sent.
+type calendarFeed struct {
+	reminders ReminderStore
+	settings  UserSettingsStore
+}
+
+func (f *calendarFeed) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx, span := beeline.StartSpan(req.Context(), "calendarFeed")
+	defer span.Send()
+
+	token := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/calendar/"), ".ics")
+	if req.Method != http.MethodGet || token == "" || strings.Contains(token, "/") || !strings.HasSuffix(req.URL.Path, ".ics") {
+		http.NotFound(w, req)
+		return
+	}
+
+	settings, err := f.settings.FindByCalendarToken(ctx, token)
+	if err != nil {
+		span.AddField("calendarFeed.error", err)
+		http.NotFound(w, req)
+		return
+	}
+	span.AddField("calendarFeed.user", settings.UserID)
+
+	now := time.Now()
+	reminders, err := f.reminders.ListByCreator(ctx, settings.UserID, now.AddDate(0, 0, -30))
+	if err != nil {
+		span.AddField("calendarFeed.error", err)
+		http.Error(w, "couldn't load reminders", http.StatusInternalServerError)
+		return
+	}
+	span.AddField("calendarFeed.count", len(reminders))
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	io.WriteString(w, reminderCalendar(reminders, now))
+}
+
+// serveCalendarFeed serves the calendar feed on REMINDER_FEED_ADDR (default :8080) until
+// the context is cancelled
+func serveCalendarFeed(ctx context.Context, feed *calendarFeed) error {
+	mux := http.NewServeMux()
+	mux.Handle("/calendar/", feed)
+
+	server := &http.Server{
+		Addr:         envOrDefault("REMINDER_FEED_ADDR", ":8080"),
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
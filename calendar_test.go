@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+func TestRecurrenceRule(t *testing.T) {
+
+	until := time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC)
+
+	tests := []struct {
+		rec      Recurrence
+		expected string
+	}{
+		{Recurrence{Every: 1, Unit: recurDay, At: "09:00"}, "FREQ=DAILY"},
+		{Recurrence{Every: 2, Unit: recurWeek}, "FREQ=WEEKLY;INTERVAL=2"},
+		{Recurrence{Every: 1, Unit: recurDay, Weekdays: []time.Weekday{time.Monday, time.Friday}}, "FREQ=WEEKLY;BYDAY=MO,FR"},
+		{Recurrence{Every: 1, Unit: recurMonth, MonthDay: 15}, "FREQ=MONTHLY;BYMONTHDAY=15"},
+		{Recurrence{Every: 1, Unit: recurMonth, MonthDay: 30}, "FREQ=MONTHLY;BYMONTHDAY=28,29,30;BYSETPOS=-1"},
+		{Recurrence{Every: 30, Unit: recurMinute, Until: until}, "FREQ=MINUTELY;INTERVAL=30;UNTIL=20261231T235959Z"},
+	}
+
+	for _, tc := range tests {
+		if rule := recurrenceRule(tc.rec); rule != tc.expected {
+			t.Errorf("recurrenceRule %v: FAILED, expected %v but got %v", tc.rec, tc.expected, rule)
+		}
+	}
+}
+
+func TestReminderCalendarRoundTrip(t *testing.T) {
+
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	reminders := []Reminder{
+		{ID: "once", Due: time.Date(2026, 10, 20, 17, 30, 0, 0, time.UTC), Message: "post memes, then; sleep", Server: "1001", Channel: "2001", SourceMessage: "3001"},
+		{ID: "weekly", Due: time.Date(2026, 10, 20, 9, 30, 0, 0, london), Message: "standup " + strings.Repeat("long ", 20),
+			Recurrence: &Recurrence{Every: 1, Unit: recurDay, Weekdays: []time.Weekday{time.Monday, time.Tuesday}, At: "09:30", Timezone: "Europe/London"}},
+	}
+
+	ics := reminderCalendar(reminders, now)
+	for _, l := range strings.Split(ics, "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("reminderCalendar: FAILED, expected lines folded to 75 octets but got %q", l)
+		}
+	}
+	if !strings.Contains(ics, "DTSTART;TZID=Europe/London:20261020T093000") {
+		t.Errorf("reminderCalendar: FAILED, expected a local start time for the recurring reminder in %v", ics)
+	}
+	// London's clocks go forward at 01:00 GMT on the last Sunday of March
+	for _, expected := range []string{"BEGIN:VTIMEZONE\r\nTZID:Europe/London\r\n", "BEGIN:DAYLIGHT\r\nDTSTART:20270328T010000\r\nTZOFFSETFROM:+0000\r\nTZOFFSETTO:+0100\r\nTZNAME:BST\r\n"} {
+		if !strings.Contains(ics, expected) {
+			t.Errorf("reminderCalendar: FAILED, expected %q in %v", expected, ics)
+		}
+	}
+	if strings.Count(ics, "BEGIN:VTIMEZONE") != 1 {
+		t.Errorf("reminderCalendar: FAILED, expected one VTIMEZONE but got %v", ics)
+	}
+
+	events, err := parseCalendar(ics, time.UTC)
+	if err != nil {
+		t.Fatalf("parseCalendar: FAILED, unexpected error %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("parseCalendar: FAILED, expected 2 events but got %d", len(events))
+	}
+	if events[0].summary != reminders[0].Message || !events[0].start.Equal(reminders[0].Due) || events[0].recurrence != nil {
+		t.Errorf("parseCalendar: FAILED, expected %v at %v but got %+v", reminders[0].Message, reminders[0].Due, events[0])
+	}
+	rec := events[1].recurrence
+	if rec == nil || rec.String() != reminders[1].Recurrence.String() || !events[1].start.Equal(reminders[1].Due) {
+		t.Errorf("parseCalendar: FAILED, expected %v but got %+v", reminders[1].Recurrence, rec)
+	}
+}
+
+func TestImportReminders(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	sent := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20261025",
+		"SUMMARY:Pay the\\, rent",
+		"BEGIN:VALARM",
+		"DESCRIPTION:This is an event reminder",
+		"TRIGGER:-P0DT0H30M0S",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART:20260101T100000Z",
+		"SUMMARY:Already happened",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART:20261001T080000Z",
+		"RRULE:FREQ=WEEKLY;COUNT=10",
+		"SUMMARY:Bins go o",
+		" ut",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	resp, err := importReminders(ctx, store, nil, testReminderMessage("import", sent), ics, reminderOptions{})
+	if err != nil {
+		t.Fatalf("importReminders: FAILED, unexpected error %v", err)
+	}
+	if expected := "Imported 2 reminders. Skipped 1 events that have already happened."; resp != expected {
+		t.Errorf("importReminders: FAILED, expected %v but got %v", expected, resp)
+	}
+
+	reminders, _ := store.ListByUser(ctx, "1001", "4001", sent)
+	if len(reminders) != 2 {
+		t.Fatalf("importReminders: FAILED, expected 2 reminders but got %v", reminders)
+	}
+	if r := reminders[1]; r.Message != "Pay the, rent" || !r.Due.Equal(time.Date(2026, 10, 25, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("importReminders: FAILED, expected the all-day event at 09:00 but got %v at %v", r.Message, r.Due)
+	}
+	r := reminders[0]
+	if r.Message != "Bins go out" || !r.Due.Equal(time.Date(2026, 10, 22, 8, 0, 0, 0, time.UTC)) || r.Recurrence == nil {
+		t.Errorf("importReminders: FAILED, expected weekly bins from the 22nd but got %v at %v", r.Message, r.Due)
+	} else if expected := time.Date(2026, 12, 3, 8, 0, 0, 0, time.UTC); !r.Recurrence.Until.Equal(expected) {
+		t.Errorf("importReminders: FAILED, expected the series to end %v but got %v", expected, r.Recurrence.Until)
+	}
+
+	if _, err := importReminders(ctx, store, nil, testReminderMessage("import", sent), "BEGIN:VEVENT\nDTSTART:20261101T100000Z\nRRULE:FREQ=SECONDLY\nEND:VEVENT", reminderOptions{}); err == nil {
+		t.Errorf("importReminders with an unsupported rule: FAILED, expected an error")
+	}
+}
+
+func TestCalendarFeed(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+
+	store.Create(ctx, Reminder{ID: "abc", Due: time.Now().Add(time.Hour), Message: "stretch", Creator: "4001", BotSource: reminderBotSource})
+
+	token, err := calendarToken(ctx, settings, "4001", false)
+	if err != nil {
+		t.Fatalf("calendarToken: FAILED, unexpected error %v", err)
+	}
+	if again, _ := calendarToken(ctx, settings, "4001", false); again != token {
+		t.Errorf("calendarToken: FAILED, expected the same token %v but got %v", token, again)
+	}
+
+	server := httptest.NewServer(&calendarFeed{reminders: store, settings: settings})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/calendar/" + token + ".ics")
+	if err != nil {
+		t.Fatalf("calendarFeed: FAILED, unexpected error %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+		t.Errorf("calendarFeed: FAILED, expected a calendar but got %v %v", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	reset, _ := calendarToken(ctx, settings, "4001", true)
+	resp, _ = http.Get(server.URL + "/calendar/" + token + ".ics")
+	resp.Body.Close()
+	if reset == token || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("calendarFeed: FAILED, expected a reset token to stop the old feed but got %v", resp.Status)
+	}
+
+	// saving other settings, even from a copy read without the token, keeps it
+	if err := settings.Save(ctx, UserSettings{UserID: "4001", Timezone: "Europe/London"}); err != nil {
+		t.Fatalf("Save: FAILED, unexpected error %v", err)
+	}
+	if s, err := settings.FindByCalendarToken(ctx, reset); err != nil || s.Timezone != "Europe/London" {
+		t.Errorf("Save: FAILED, expected the token to be kept with the new timezone but got %v (%v)", s, err)
+	}
+
+	if _, err := revokeCalendarFeed(ctx, settings, "4001"); err != nil {
+		t.Fatalf("revokeCalendarFeed: FAILED, unexpected error %v", err)
+	}
+	resp, _ = http.Get(server.URL + "/calendar/" + reset + ".ics")
+	resp.Body.Close()
+	if s, _ := settings.Get(ctx, "4001"); s.CalendarToken != "" || s.Timezone != "Europe/London" || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revokeCalendarFeed: FAILED, expected only the token to be removed but got %v and %v", s, resp.Status)
+	}
+}
@@ -33,25 +33,29 @@ func sendResponse(ctx context.Context, s *discordgo.Session, cid string, m strin
 
 	ctx, span := beeline.StartSpan(ctx, "send_response")
 	defer span.Send()
-	beeline.AddField(ctx, "response", m)
+	beeline.AddField(ctx, "response.length", len(m))
 	beeline.AddField(ctx, "chennel", cid)
 
 	s.ChannelMessageSend(cid, m)
 
 }
 
-func sendReply(ctx context.Context, s *discordgo.Session, m string, om *discordgo.MessageReference) {
+func sendReply(ctx context.Context, s *discordgo.Session, m string, om *discordgo.MessageReference) error {
 
 	ctx, span := beeline.StartSpan(ctx, "sendReply")
 	defer span.Send()
 
-	span.AddField("sendReply.response", m)
+	span.AddField("sendReply.response.length", len(m))
 	span.AddField("sendReply.originalMessage.id", om.MessageID)
 	span.AddField("sendReply.originalMessage.guildID", om.GuildID)
 	span.AddField("sendReply.originalMessage.channelID", om.ChannelID)
 
-	s.ChannelMessageSendReply(om.ChannelID, m, om)
+	_, err := s.ChannelMessageSendReply(om.ChannelID, m, om)
+	if err != nil {
+		span.AddField("sendReply.error", err)
+	}
 
+	return err
 }
 
 func chooseRandom(opt []string) (string, int) {
@@ -283,7 +287,26 @@ func getRelationship(ctx context.Context) (relationship, error) {
 	return rel, nil
 }
 
-func getTime(ctx context.Context, t time.Time, s string) (string, error) {
+// memberTimezone looks up a member's timezone in MEMBER_TIMEZONES, a JSON object of
+// lower case names to IANA timezones. It only seeds the timezones members register with
+// !time set, see memberTimezones.
+func memberTimezone(name string) (*time.Location, error) {
+	memberTimes := make(map[string]string)
+
+	err := json.Unmarshal([]byte(os.Getenv("MEMBER_TIMEZONES")), &memberTimes)
+	if err != nil {
+		return nil, err
+	}
+
+	if memberTimes[name] == "" {
+		return nil, fmt.Errorf("User not found")
+	}
+
+	return time.LoadLocation(memberTimes[name])
+}
+
+// getTime shows the time t where the member s is, finding their timezone with lookup
+func getTime(ctx context.Context, t time.Time, s string, lookup locationLookup) (string, error) {
 	ctx, span := beeline.StartSpan(ctx, "getTime")
 	defer span.Send()
 
@@ -295,30 +318,15 @@ func getTime(ctx context.Context, t time.Time, s string) (string, error) {
 		return "no user specified", nil
 	}
 
-	memberTimes := make(map[string]string)
-
-	err := json.Unmarshal([]byte(os.Getenv("MEMBER_TIMEZONES")), &memberTimes)
-	if err != nil {
-		span.AddField("timezone.error", err)
-		return "", err
-	}
-
-	if memberTimes[s] == "" {
-		err := fmt.Errorf("User not found")
-		span.AddField("timezone.error", err)
-		return "", err
-	}
-
-	location, err := time.LoadLocation(memberTimes[s])
+	name, location, err := lookup(ctx, s)
 	if err != nil {
 		span.AddField("timezone.error", err)
 		return "", err
 	}
-	span.AddField("timezone.location.raw", memberTimes[s])
 	span.AddField("timezone.location.time", location)
 
 	raw := t.In(location)
-	result := fmt.Sprintf("%s : %02d:%02d, %d %s %d, (%s)", s, raw.Hour(), raw.Minute(), raw.Day(), raw.Month(), raw.Year(), raw.Location())
+	result := fmt.Sprintf("%s : %02d:%02d, %d %s %d, (%s)", name, raw.Hour(), raw.Minute(), raw.Day(), raw.Month(), raw.Year(), raw.Location())
 
 	span.AddField("timezone.result", result)
 
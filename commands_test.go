@@ -57,7 +57,7 @@ func TestGetTime(t *testing.T) {
 
 		ctx := context.Background()
 
-		res, err := getTime(ctx, parsed, test.input)
+		res, err := getTime(ctx, parsed, test.input, envLookup)
 
 		if test.hasError == true {
 			if err.Error() != test.result {
@@ -70,3 +70,9 @@ func TestGetTime(t *testing.T) {
 		}
 	}
 }
+
+// envLookup finds timezones by name in MEMBER_TIMEZONES alone
+func envLookup(ctx context.Context, name string) (string, *time.Location, error) {
+	loc, err := memberTimezone(name)
+	return name, loc, err
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/honeycombio/beeline-go"
+)
+
+// reminderSession is the part of the Discord session used to deliver reminders
+type reminderSession interface {
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error)
+	UserChannelCreate(recipientID string) (*discordgo.Channel, error)
+}
+
+// reminderSnoozes are the snooze buttons put on a delivered reminder
+var reminderSnoozes = []struct {
+	label string
+	value string
+}{
+	{"Snooze 10m", "10m"},
+	{"Snooze 1h", "1h"},
+	{"Tomorrow", "tomorrow"},
+}
+
+const snoozeCustomIDPrefix = "remindme:snooze:"
+
+// snoozeButtons returns a row of buttons that snooze the reminder, each with a custom ID
+// of remindme:snooze:<id>:<duration>
+func snoozeButtons(id string) []discordgo.MessageComponent {
+	var buttons []discordgo.MessageComponent
+	for _, snooze := range reminderSnoozes {
+		buttons = append(buttons, discordgo.Button{
+			Label:    snooze.label,
+			Style:    discordgo.SecondaryButton,
+			CustomID: snoozeCustomIDPrefix + id + ":" + snooze.value,
+		})
+	}
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+}
+
+// reminderMessage addresses the reminder to its recipients and roles, or to the creator if
+// it has none. Only the people it's addressed to are pinged, not anyone mentioned in the
+// reminder text.
+func reminderMessage(r Reminder, recipients []string) *discordgo.MessageSend {
+	if len(recipients) == 0 && len(r.Roles) == 0 {
+		return &discordgo.MessageSend{
+			Content:         fmt.Sprintf("Hey <@%s>, remember %s", r.Creator, r.Message),
+			AllowedMentions: &discordgo.MessageAllowedMentions{Users: []string{r.Creator}},
+		}
+	}
+
+	var mentions []string
+	for _, user := range recipients {
+		mentions = append(mentions, "<@"+user+">")
+	}
+	for _, role := range r.Roles {
+		mentions = append(mentions, "<@&"+role+">")
+	}
+
+	return &discordgo.MessageSend{
+		Content: fmt.Sprintf("Hey %s, <@%s> asked me to remind you: %s", strings.Join(mentions, " "), r.Creator, r.Message),
+		AllowedMentions: &discordgo.MessageAllowedMentions{
+			Users: recipients,
+			Roles: r.Roles,
+		},
+	}
+}
+
+// deliverReminder sends the reminder to its target, the channel it was created in by
+// default, a channel chosen for it, or by DM.
+func deliverReminder(ctx context.Context, session reminderSession, settings UserSettingsStore, r Reminder) error {
+	ctx, span := beeline.StartSpan(ctx, "deliverReminder")
+	defer span.Send()
+
+	span.AddField("deliverReminder.id", r.ID)
+	span.AddField("deliverReminder.due", r.Due)
+	span.AddField("deliverReminder.length", len(r.Message))
+	span.AddField("deliverReminder.server", r.Server)
+	span.AddField("deliverReminder.creator", r.Creator)
+	span.AddField("deliverReminder.channel", r.Channel)
+	span.AddField("deliverReminder.sourceMessage", r.SourceMessage)
+	span.AddField("deliverReminder.sourceTimestamp", r.SourceTimestamp)
+	span.AddField("deliverReminder.botSource", r.BotSource)
+	span.AddField("deliverReminder.attempts", r.Attempts)
+
+	out, err := optedOut(ctx, settings, r.Creator, r.Recipients)
+	if err != nil {
+		span.AddField("deliverReminder.error", err)
+		return err
+	}
+
+	var recipients []string
+	for _, user := range r.Recipients {
+		if !out[user] {
+			recipients = append(recipients, user)
+		}
+	}
+	span.AddField("deliverReminder.recipients", len(recipients))
+	span.AddField("deliverReminder.roles", len(r.Roles))
+
+	message := reminderMessage(r, recipients)
+	message.Components = snoozeButtons(r.ID)
+
+	span.AddField("deliverReminder.target", r.Target)
+
+	switch r.Target {
+	case reminderTargetDM:
+		err = deliverByDM(ctx, session, r, recipients, message)
+	case reminderTargetChannel:
+		err = deliverToChannel(ctx, session, r, message)
+	default:
+		err = deliverToSource(ctx, session, r, message)
+	}
+
+	if err != nil {
+		span.AddField("deliverReminder.error", err)
+	}
+	return err
+}
+
+// deliverToSource replies to the message that created the reminder. If that message has
+// been deleted it posts in the channel instead, and if the channel can't be used it sends
+// the creator a DM.
+func deliverToSource(ctx context.Context, session reminderSession, r Reminder, message *discordgo.MessageSend) error {
+	reply := *message
+	reply.Reference = &discordgo.MessageReference{
+		MessageID: r.SourceMessage,
+		ChannelID: r.Channel,
+		GuildID:   r.Server,
+	}
+
+	_, err := session.ChannelMessageSendComplex(r.Channel, &reply)
+	if err == nil {
+		beeline.AddField(ctx, "deliverReminder.sentTo", "reply")
+		return nil
+	}
+	beeline.AddField(ctx, "deliverReminder.reply.error", err)
+
+	if hasDiscordErrorCode(err, discordgo.ErrCodeUnknownMessage, discordgo.ErrCodeInvalidFormBody) {
+		_, err = session.ChannelMessageSendComplex(r.Channel, message)
+		if err == nil {
+			beeline.AddField(ctx, "deliverReminder.sentTo", "channel")
+			return nil
+		}
+		beeline.AddField(ctx, "deliverReminder.channel.error", err)
+	}
+
+	if isChannelUnavailable(err) {
+		return deliverFallbackDM(ctx, session, r, message)
+	}
+	return err
+}
+
+// deliverToChannel posts in the channel chosen for the reminder, sending the creator a DM
+// if the channel can't be used
+func deliverToChannel(ctx context.Context, session reminderSession, r Reminder, message *discordgo.MessageSend) error {
+	_, err := session.ChannelMessageSendComplex(r.TargetChannel, message)
+	if err == nil {
+		beeline.AddField(ctx, "deliverReminder.sentTo", "channel")
+		return nil
+	}
+	beeline.AddField(ctx, "deliverReminder.channel.error", err)
+
+	if isChannelUnavailable(err) {
+		return deliverFallbackDM(ctx, session, r, message)
+	}
+	return err
+}
+
+// deliverByDM sends each recipient a DM, or the creator if there aren't any. It only fails
+// if nobody could be sent one, so a retry doesn't repeat the reminder for everyone else.
+func deliverByDM(ctx context.Context, session reminderSession, r Reminder, recipients []string, message *discordgo.MessageSend) error {
+	if len(recipients) == 0 {
+		recipients = []string{r.Creator}
+	}
+
+	var err error
+	sent := 0
+	for _, user := range recipients {
+		if dmErr := sendDM(session, user, message); dmErr != nil {
+			beeline.AddField(ctx, "deliverReminder.dm.error", dmErr)
+			err = dmErr
+			continue
+		}
+		sent++
+	}
+
+	beeline.AddField(ctx, "deliverReminder.dm.sent", sent)
+	if sent > 0 {
+		beeline.AddField(ctx, "deliverReminder.sentTo", "dm")
+		return nil
+	}
+	return err
+}
+
+func deliverFallbackDM(ctx context.Context, session reminderSession, r Reminder, message *discordgo.MessageSend) error {
+	if err := sendDM(session, r.Creator, message); err != nil {
+		beeline.AddField(ctx, "deliverReminder.dm.error", err)
+		return err
+	}
+	beeline.AddField(ctx, "deliverReminder.sentTo", "dm")
+	return nil
+}
+
+func sendDM(session reminderSession, userID string, message *discordgo.MessageSend) error {
+	channel, err := session.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+	_, err = session.ChannelMessageSendComplex(channel.ID, message)
+	return err
+}
+
+// isChannelUnavailable reports whether the bot can no longer post in a channel
+func isChannelUnavailable(err error) bool {
+	return hasDiscordErrorCode(err, discordgo.ErrCodeUnknownChannel, discordgo.ErrCodeMissingAccess, discordgo.ErrCodeMissingPermissions)
+}
+
+func hasDiscordErrorCode(err error, codes ...int) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Message == nil {
+		return false
+	}
+
+	for _, code := range codes {
+		if restErr.Message.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientError reports whether a failed delivery is worth retrying: rate limits,
+// Discord server errors and network problems
+func isTransientError(err error) bool {
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) {
+		if restErr.Response == nil {
+			return true
+		}
+		code := restErr.Response.StatusCode
+		return code == http.StatusTooManyRequests || code >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryBackoff doubles the wait after each attempt, starting at 30 seconds and capped at
+// 30 minutes
+func retryBackoff(attempts int) time.Duration {
+	wait := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= 30*time.Minute {
+			return 30 * time.Minute
+		}
+	}
+	return wait
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+type fakeReminderSession struct {
+	replyErr   error
+	channelErr error
+	sent       []string
+}
+
+func (f *fakeReminderSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	if data.Reference != nil {
+		if f.replyErr != nil {
+			return nil, f.replyErr
+		}
+		f.sent = append(f.sent, "reply:"+channelID)
+		return &discordgo.Message{}, nil
+	}
+
+	if f.channelErr != nil && !strings.HasPrefix(channelID, "dm-") {
+		return nil, f.channelErr
+	}
+	f.sent = append(f.sent, "send:"+channelID)
+	return &discordgo.Message{}, nil
+}
+
+func (f *fakeReminderSession) UserChannelCreate(recipientID string) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: "dm-" + recipientID}, nil
+}
+
+func discordError(status int, code int) error {
+	return &discordgo.RESTError{
+		Response: &http.Response{StatusCode: status},
+		Message:  &discordgo.APIErrorMessage{Code: code},
+	}
+}
+
+func TestDeliverReminderFallback(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		replyErr   error
+		channelErr error
+		want       string
+		wantErr    bool
+	}{
+		{"reply", nil, nil, "reply:200", false},
+		{"source message deleted", discordError(http.StatusBadRequest, discordgo.ErrCodeUnknownMessage), nil, "send:200", false},
+		{"channel deleted", discordError(http.StatusNotFound, discordgo.ErrCodeUnknownChannel), nil, "send:dm-100", false},
+		{"message deleted and access lost", discordError(http.StatusBadRequest, discordgo.ErrCodeUnknownMessage), discordError(http.StatusForbidden, discordgo.ErrCodeMissingAccess), "send:dm-100", false},
+		{"discord down", discordError(http.StatusBadGateway, 0), nil, "", true},
+	}
+
+	for _, tc := range tests {
+		session := &fakeReminderSession{replyErr: tc.replyErr, channelErr: tc.channelErr}
+		err := deliverReminder(context.Background(), session, nil, Reminder{ID: "abc234", Channel: "200", Creator: "100", Message: "stretch"})
+
+		if (err != nil) != tc.wantErr {
+			t.Errorf("deliverReminder %v: FAILED, expected error %v but got %v", tc.name, tc.wantErr, err)
+		}
+		if tc.want != "" && (len(session.sent) != 1 || session.sent[0] != tc.want) {
+			t.Errorf("deliverReminder %v: FAILED, expected %v but got %v", tc.name, tc.want, session.sent)
+		}
+	}
+}
+
+func TestDeliverReminderTargets(t *testing.T) {
+
+	ctx := context.Background()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+	settings.Save(ctx, UserSettings{UserID: "102", OptOutOthers: true})
+
+	session := &fakeReminderSession{}
+	err := deliverReminder(ctx, session, settings, Reminder{ID: "abc234", Channel: "200", Creator: "100", Recipients: []string{"101", "102"}, Target: reminderTargetDM})
+	if err != nil || strings.Join(session.sent, ",") != "send:dm-101" {
+		t.Errorf("deliverReminder by DM: FAILED, expected a DM to 101 only but got %v (%v)", session.sent, err)
+	}
+
+	session = &fakeReminderSession{}
+	err = deliverReminder(ctx, session, settings, Reminder{ID: "abc234", Channel: "200", Creator: "100", Target: reminderTargetChannel, TargetChannel: "300"})
+	if err != nil || strings.Join(session.sent, ",") != "send:300" {
+		t.Errorf("deliverReminder to a channel: FAILED, expected a message in 300 but got %v (%v)", session.sent, err)
+	}
+
+	session = &fakeReminderSession{channelErr: discordError(http.StatusForbidden, discordgo.ErrCodeMissingAccess)}
+	err = deliverReminder(ctx, session, settings, Reminder{ID: "abc234", Channel: "200", Creator: "100", Target: reminderTargetChannel, TargetChannel: "300"})
+	if err != nil || strings.Join(session.sent, ",") != "send:dm-100" {
+		t.Errorf("deliverReminder to a locked channel: FAILED, expected a DM to the creator but got %v (%v)", session.sent, err)
+	}
+}
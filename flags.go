@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// Feature flag keys checked by registered commands. Every key here must also be
+// declared in flags.json so Update-FeatureFlags.ps1 creates it in Optimizely.
+const (
+	flagLunch             = "lunch-command"
+	flagMinecraft         = "mc-commands"
+	flagMinecraftAdmin    = "mc-admin"
+	flagRelationship      = "relationship-command"
+	flagReminder          = "reminder-command"
+	flagReminderRoles     = "reminder-roles"
+	flagReminderUnlimited = "reminder-unlimited"
+	flagTimezone          = "timezone-command"
+	flagRollDice          = "rolldice-command"
+)
+
+type declaredFlag struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func loadDeclaredFlags(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var declared []declaredFlag
+	if err := json.Unmarshal(data, &declared); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var keys []string
+	for _, f := range declared {
+		keys = append(keys, f.Key)
+	}
+
+	return keys, nil
+}
+
+// registeredFlags returns every flag key referenced by the registered commands
+func registeredFlags(commands []botCommand) []string {
+	var keys []string
+	for _, c := range commands {
+		if c.flag != "" {
+			keys = append(keys, c.flag)
+		}
+		keys = append(keys, c.checks...)
+		keys = append(keys, registeredFlags(c.subcommands)...)
+	}
+
+	return keys
+}
+
+// checkFlags compares the flags referenced in code with the declared flags. Undeclared
+// flags are referenced but missing from flags.json, orphaned flags are declared but
+// never checked.
+func checkFlags(registered []string, declared []string) (undeclared []string, orphaned []string) {
+	inCode := make(map[string]bool)
+	for _, k := range registered {
+		inCode[k] = true
+	}
+
+	inFile := make(map[string]bool)
+	for _, k := range declared {
+		inFile[k] = true
+	}
+
+	for k := range inCode {
+		if !inFile[k] {
+			undeclared = append(undeclared, k)
+		}
+	}
+
+	for k := range inFile {
+		if !inCode[k] {
+			orphaned = append(orphaned, k)
+		}
+	}
+
+	sort.Strings(undeclared)
+	sort.Strings(orphaned)
+
+	return undeclared, orphaned
+}
+
+// runFlagsCommand handles "go-discord-bot flags check" and returns the process exit code
+func runFlagsCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(out, "usage: go-discord-bot flags check [-file flags.json]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("flags check", flag.ContinueOnError)
+	fs.SetOutput(out)
+	file := fs.String("file", "flags.json", "path to the declared feature flags")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	declared, err := loadDeclaredFlags(*file)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	undeclared, orphaned := checkFlags(registeredFlags((&botService{}).commands()), declared)
+
+	for _, k := range undeclared {
+		fmt.Fprintf(out, "undeclared flag: %s is checked by a command but missing from %s\n", k, *file)
+	}
+	for _, k := range orphaned {
+		fmt.Fprintf(out, "orphaned flag: %s is declared in %s but never checked\n", k, *file)
+	}
+
+	if len(undeclared) > 0 || len(orphaned) > 0 {
+		return 1
+	}
+
+	fmt.Fprintln(out, "flags ok")
+	return 0
+}
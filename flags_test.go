@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type TestCheckFlagsItem struct {
+	registered []string
+	declared   []string
+	undeclared []string
+	orphaned   []string
+}
+
+func TestCheckFlags(t *testing.T) {
+
+	testCases := []TestCheckFlagsItem{
+		{
+			[]string{"a", "b"},
+			[]string{"a", "b"},
+			nil,
+			nil,
+		},
+		{
+			[]string{"a", "b", "b"},
+			[]string{"a"},
+			[]string{"b"},
+			nil,
+		},
+		{
+			[]string{"a"},
+			[]string{"c", "a", "b"},
+			nil,
+			[]string{"b", "c"},
+		},
+	}
+
+	for _, test := range testCases {
+		undeclared, orphaned := checkFlags(test.registered, test.declared)
+
+		if !reflect.DeepEqual(undeclared, test.undeclared) {
+			t.Errorf("checkFlags with args %v %v: FAILED, expected undeclared %v but got %v", test.registered, test.declared, test.undeclared, undeclared)
+		}
+		if !reflect.DeepEqual(orphaned, test.orphaned) {
+			t.Errorf("checkFlags with args %v %v: FAILED, expected orphaned %v but got %v", test.registered, test.declared, test.orphaned, orphaned)
+		}
+	}
+}
+
+func TestFlagsFileMatchesCommands(t *testing.T) {
+
+	declared, err := loadDeclaredFlags("flags.json")
+	if err != nil {
+		t.Fatalf("loading flags.json: %v", err)
+	}
+
+	undeclared, orphaned := checkFlags(registeredFlags((&botService{}).commands()), declared)
+
+	for _, k := range undeclared {
+		t.Errorf("flag %s is checked by a command but not declared in flags.json", k)
+	}
+	for _, k := range orphaned {
+		t.Errorf("flag %s is declared in flags.json but no command checks it", k)
+	}
+}
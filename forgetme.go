@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/honeycombio/beeline-go"
+)
+
+// reminderRetention is how long delivered and failed reminders are kept after they were
+// due, REMINDER_RETENTION_DAYS (default 30). Zero keeps them forever.
+func reminderRetention() time.Duration {
+	return time.Duration(envOrDefaultInt("REMINDER_RETENTION_DAYS", 30)) * 24 * time.Hour
+}
+
+// purgeReminders deletes finished reminders older than retention every interval until the
+// context is cancelled
+func purgeReminders(ctx context.Context, store ReminderStore, retention time.Duration, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purgeFinished(ctx, store, retention)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeFinished(ctx context.Context, store ReminderStore, retention time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	ctx, span := beeline.StartSpan(ctx, "purgeReminders")
+	defer span.Send()
+
+	before := time.Now().Add(-retention)
+	span.AddField("purgeReminders.before", before)
+
+	n, err := store.DeleteFinished(ctx, before)
+	if err != nil {
+		span.AddField("purgeReminders.error", err)
+		return
+	}
+	span.AddField("purgeReminders.deleted", n)
+}
+
+const forgetMeCustomIDPrefix = "forgetme:"
+
+// forgetMePrompt tells the user what forgetme will delete, with a button to confirm it
+func forgetMePrompt(ctx context.Context, store ReminderStore, settings UserSettingsStore, userID string) (*discordgo.MessageSend, error) {
+	ctx, span := beeline.StartSpan(ctx, "forgetMePrompt")
+	defer span.Send()
+
+	created, err := store.ListByCreator(ctx, userID, time.Time{})
+	if err != nil {
+		span.AddField("forgetMePrompt.error", err)
+		return nil, err
+	}
+	others, err := store.ListByRecipient(ctx, userID)
+	if err != nil {
+		span.AddField("forgetMePrompt.error", err)
+		return nil, err
+	}
+	var recipient []Reminder
+	for _, r := range others {
+		if r.Creator != userID {
+			recipient = append(recipient, r)
+		}
+	}
+	saved, err := settings.Get(ctx, userID)
+	if err != nil {
+		span.AddField("forgetMePrompt.error", err)
+		return nil, err
+	}
+
+	var items []string
+	if len(created) > 0 {
+		items = append(items, fmt.Sprintf("the %d reminders you created", len(created)))
+	}
+	if len(recipient) > 0 {
+		items = append(items, fmt.Sprintf("you from %d reminders other people set for you", len(recipient)))
+	}
+	if saved != (UserSettings{UserID: userID}) {
+		items = append(items, "your settings, including your timezone, working hours, reminder defaults and calendar feed")
+	}
+
+	if len(items) == 0 {
+		return &discordgo.MessageSend{Content: "I don't have any data stored about you."}, nil
+	}
+
+	return &discordgo.MessageSend{
+		Content: fmt.Sprintf("This will permanently delete %s. Press the button to confirm, or ignore this to keep it.", joinList(items)),
+		Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Label:    "Delete my data",
+				Style:    discordgo.DangerButton,
+				CustomID: forgetMeCustomIDPrefix + userID,
+			},
+		}}},
+	}, nil
+}
+
+// forgetUser deletes everything stored about the user: the reminders they created, their
+// place on reminders other people set and their settings. Reminders set only for them, with
+// nobody else or no role left to send them to, are deleted. It reports what was removed.
+func forgetUser(ctx context.Context, store ReminderStore, settings UserSettingsStore, scheduler *reminderScheduler, userID string) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "forgetUser")
+	defer span.Send()
+
+	span.AddField("forgetUser.user", userID)
+
+	created, err := store.ListByCreator(ctx, userID, time.Time{})
+	if err != nil {
+		span.AddField("forgetUser.error", err)
+		return "", err
+	}
+	deleted, err := store.DeleteByCreator(ctx, userID)
+	if err != nil {
+		span.AddField("forgetUser.error", err)
+		return "", err
+	}
+	for _, r := range created {
+		scheduler.Unschedule(r.ID)
+	}
+	span.AddField("forgetUser.deleted", deleted)
+
+	recipient, err := store.ListByRecipient(ctx, userID)
+	if err != nil {
+		span.AddField("forgetUser.error", err)
+		return "", err
+	}
+	removed, cancelled := 0, 0
+	for _, r := range recipient {
+		var others []string
+		for _, user := range r.Recipients {
+			if user != userID {
+				others = append(others, user)
+			}
+		}
+		if len(others) == 0 && len(r.Roles) == 0 {
+			if err := store.Delete(ctx, r.ID); err != nil {
+				span.AddField("forgetUser.error", err)
+				return "", err
+			}
+			scheduler.Unschedule(r.ID)
+			cancelled++
+			continue
+		}
+		if err := store.SetRecipients(ctx, r.ID, others); err != nil {
+			span.AddField("forgetUser.error", err)
+			return "", err
+		}
+
+		// replace any queued copy that would still mention them
+		scheduler.Unschedule(r.ID)
+		if r.Status == reminderPending {
+			r.Recipients = others
+			scheduler.Schedule(r)
+		}
+		removed++
+	}
+	span.AddField("forgetUser.removed", removed)
+	span.AddField("forgetUser.cancelled", cancelled)
+
+	hadSettings, err := settings.Delete(ctx, userID)
+	if err != nil {
+		span.AddField("forgetUser.error", err)
+		return "", err
+	}
+
+	items := []string{fmt.Sprintf("%d reminders you created", deleted)}
+	if removed > 0 {
+		items = append(items, fmt.Sprintf("you from %d reminders other people set for you", removed))
+	}
+	if cancelled > 0 {
+		items = append(items, fmt.Sprintf("%d reminders other people set only for you", cancelled))
+	}
+	if hadSettings {
+		items = append(items, "your settings")
+	}
+	return fmt.Sprintf("Deleted %s.", joinList(items)), nil
+}
+
+// joinList joins items as "a, b and c"
+func joinList(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
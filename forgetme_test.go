@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+func TestForgetUser(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+	due := time.Now().Add(time.Hour)
+
+	for _, r := range []Reminder{
+		{Message: "mine", Creator: "1001", Recipients: []string{"1001"}, Due: due},
+		{Message: "mine for them", Creator: "1001", Recipients: []string{"1002"}, Due: due},
+		{Message: "theirs for both", Creator: "1002", Recipients: []string{"1001", "1002"}, Due: due},
+		{Message: "theirs", Creator: "1002", Recipients: []string{"1002"}, Due: due},
+		{Message: "theirs for me", Creator: "1002", Recipients: []string{"1001"}, Due: due},
+		{Message: "theirs for me and a role", Creator: "1002", Recipients: []string{"1001"}, Roles: []string{"5001"}, Due: due},
+	} {
+		r.BotSource = reminderBotSource
+		if _, err := store.Create(ctx, r); err != nil {
+			t.Fatalf("forgetUser: FAILED, unexpected error %v", err)
+		}
+	}
+	settings.Save(ctx, UserSettings{UserID: "1001", OptOutOthers: true})
+
+	prompt, err := forgetMePrompt(ctx, store, settings, "1001")
+	if err != nil {
+		t.Fatalf("forgetMePrompt: FAILED, unexpected error %v", err)
+	}
+	if expected := "the 2 reminders you created, you from 3 reminders other people set for you and your settings"; !strings.Contains(prompt.Content, expected) {
+		t.Errorf("forgetMePrompt: FAILED, expected %v but got %v", expected, prompt.Content)
+	}
+	if len(prompt.Components) != 1 || prompt.Components[0].(discordgo.ActionsRow).Components[0].(discordgo.Button).CustomID != "forgetme:1001" {
+		t.Errorf("forgetMePrompt: FAILED, expected a confirm button but got %v", prompt.Components)
+	}
+
+	resp, err := forgetUser(ctx, store, settings, nil, "1001")
+	if err != nil {
+		t.Fatalf("forgetUser: FAILED, unexpected error %v", err)
+	}
+	if expected := "Deleted 2 reminders you created, you from 2 reminders other people set for you, 1 reminders other people set only for you and your settings."; resp != expected {
+		t.Errorf("forgetUser: FAILED, expected %v but got %v", expected, resp)
+	}
+
+	left, _ := store.ListByCreator(ctx, "1002", time.Time{})
+	// the reminder set only for them has nobody left to go to, but a role still gets the other
+	if len(left) != 3 {
+		t.Fatalf("forgetUser: FAILED, expected the other user's 3 reminders to be kept but got %v", left)
+	}
+	for _, r := range left {
+		for _, user := range r.Recipients {
+			if user == "1001" {
+				t.Errorf("forgetUser: FAILED, expected the user to be removed from %v but got %v", r.Message, r.Recipients)
+			}
+		}
+	}
+	if s, _ := settings.Get(ctx, "1001"); s.OptOutOthers {
+		t.Errorf("forgetUser: FAILED, expected the settings to be deleted but got %v", s)
+	}
+
+	prompt, _ = forgetMePrompt(ctx, store, settings, "1001")
+	if expected := "I don't have any data stored about you."; prompt.Content != expected {
+		t.Errorf("forgetMePrompt: FAILED, expected %v but got %v", expected, prompt.Content)
+	}
+}
+
+func TestPurgeFinished(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	now := time.Now()
+
+	for _, r := range []Reminder{
+		{Message: "old", Creator: "1001", Due: now.AddDate(0, 0, -40), Status: reminderDelivered},
+		{Message: "old failure", Creator: "1001", Due: now.AddDate(0, 0, -40), Status: reminderFailed},
+		{Message: "recent", Creator: "1001", Due: now.AddDate(0, 0, -10), Status: reminderDelivered},
+		{Message: "overdue", Creator: "1001", Due: now.AddDate(0, 0, -40), Status: reminderPending},
+	} {
+		r.BotSource = reminderBotSource
+		store.Create(ctx, r)
+	}
+
+	purgeFinished(ctx, store, 30*24*time.Hour)
+
+	left, _ := store.ListByCreator(ctx, "1001", time.Time{})
+	var messages []string
+	for _, r := range left {
+		messages = append(messages, r.Message)
+	}
+	if expected := "overdue,recent"; strings.Join(messages, ",") != expected {
+		t.Errorf("purgeFinished: FAILED, expected %v but got %v", expected, messages)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/honeycombio/beeline-go"
+)
+
+// denialMode controls how the bot tells a user they can't run a gated command
+type denialMode string
+
+const (
+	denialReply  denialMode = "reply"
+	denialReact  denialMode = "react"
+	denialDM     denialMode = "dm"
+	denialSilent denialMode = "silent"
+)
+
+const denialMessage = "Command not allowed"
+
+type denialConfig struct {
+	defaultMode denialMode
+	guildModes  map[string]denialMode
+	emoji       string
+}
+
+func parseDenialMode(s string) (denialMode, error) {
+	switch mode := denialMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case denialReply, denialReact, denialDM, denialSilent:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown denial mode %q, expected reply, react, dm or silent", s)
+	}
+}
+
+// loadDenialConfig reads the denial settings from the environment:
+// COMMAND_DENIAL_MODE sets the default mode, COMMAND_DENIAL_GUILDS is a JSON map of
+// guild ID to mode and COMMAND_DENIAL_EMOJI is the reaction used by the react mode.
+func loadDenialConfig() (denialConfig, error) {
+	config := denialConfig{
+		defaultMode: denialReply,
+		guildModes:  make(map[string]denialMode),
+		emoji:       "🚫",
+	}
+
+	if s := os.Getenv("COMMAND_DENIAL_MODE"); s != "" {
+		mode, err := parseDenialMode(s)
+		if err != nil {
+			return config, err
+		}
+		config.defaultMode = mode
+	}
+
+	if s := os.Getenv("COMMAND_DENIAL_GUILDS"); s != "" {
+		raw := make(map[string]string)
+		if err := json.Unmarshal([]byte(s), &raw); err != nil {
+			return config, err
+		}
+		for guild, m := range raw {
+			mode, err := parseDenialMode(m)
+			if err != nil {
+				return config, fmt.Errorf("guild %s: %w", guild, err)
+			}
+			config.guildModes[guild] = mode
+		}
+	}
+
+	if s := os.Getenv("COMMAND_DENIAL_EMOJI"); s != "" {
+		config.emoji = s
+	}
+
+	return config, nil
+}
+
+func (d denialConfig) modeFor(guildID string) denialMode {
+	if mode, ok := d.guildModes[guildID]; ok {
+		return mode
+	}
+	if d.defaultMode == "" {
+		return denialReply
+	}
+	return d.defaultMode
+}
+
+// commandAllowed checks the gates declared on a command for the message author. When a
+// command declares both a flag and permissions the author needs both. Commands open to
+// direct messages skip their flag there.
+func (b *botService) commandAllowed(ctx context.Context, s *discordgo.Session, c botCommand, m *discordgo.MessageCreate, roles []string) (bool, string) {
+	if c.permissions != 0 {
+		perms, err := memberChannelPermissions(s, m.Author.ID, m.ChannelID)
+		if err != nil {
+			beeline.AddField(ctx, "permissions.error", err)
+			return false, "permissions"
+		}
+		beeline.AddField(ctx, "permissions.member", perms)
+		beeline.AddField(ctx, "permissions.required", c.permissions)
+
+		if !hasPermissions(perms, c.permissions) {
+			return false, "permissions"
+		}
+	}
+
+	// a DM has no server roles for the flag to target
+	if c.flag == "" || (m.GuildID == "" && c.directMessages) {
+		return true, ""
+	}
+
+	if b.flags == nil || !getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, c.flag) {
+		return false, "flag"
+	}
+
+	return true, ""
+}
+
+// allowed reports whether the author can use part of a command, either because the flag
+// is enabled for them or because they have the permissions in the channel
+func (b *botService) allowed(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string, flag string, permissions int64) bool {
+	if b.flags != nil && getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, flag) {
+		return true
+	}
+
+	perms, err := memberChannelPermissions(s, m.Author.ID, m.ChannelID)
+	if err != nil {
+		beeline.AddField(ctx, "permissions.error", err)
+		return false
+	}
+	return hasPermissions(perms, permissions)
+}
+
+// memberChannelPermissions computes the member's permissions in the channel, using the
+// gateway state where possible and falling back to the REST API.
+func memberChannelPermissions(s *discordgo.Session, userID string, channelID string) (int64, error) {
+	if s.State != nil {
+		if perms, err := s.State.UserChannelPermissions(userID, channelID); err == nil {
+			return perms, nil
+		}
+	}
+
+	return s.UserChannelPermissions(userID, channelID)
+}
+
+func hasPermissions(perms int64, required int64) bool {
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+
+	return perms&required == required
+}
+
+// denyCommand lets the author know a command was refused using the guild's denial mode
+func (b *botService) denyCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c botCommand, reason string) {
+	ctx, span := beeline.StartSpan(ctx, "denyCommand")
+	defer span.Send()
+
+	mode := b.denial.modeFor(m.GuildID)
+
+	span.AddField("denied.command", c.name)
+	span.AddField("denied.reason", reason)
+	span.AddField("denied.flag", c.flag)
+	span.AddField("denied.permissions", c.permissions)
+	span.AddField("denied.mode", mode)
+	span.AddField("denied.author.id", m.Author.ID)
+	span.AddField("denied.guild", m.GuildID)
+
+	switch mode {
+	case denialSilent:
+		return
+	case denialReact:
+		if err := s.MessageReactionAdd(m.ChannelID, m.ID, b.denial.emoji); err != nil {
+			span.AddField("denied.error", err)
+		}
+	case denialDM:
+		channel, err := s.UserChannelCreate(m.Author.ID)
+		if err != nil {
+			span.AddField("denied.error", err)
+			return
+		}
+		sendResponse(ctx, s, channel.ID, fmt.Sprintf("%s: %s", denialMessage, c.name))
+	default:
+		sendResponse(ctx, s, m.ChannelID, denialMessage)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+	"github.com/optimizely/go-sdk/pkg/entities"
+)
+
+type TestDenialModeItem struct {
+	guild  string
+	result denialMode
+}
+
+func TestDenialConfig(t *testing.T) {
+
+	os.Setenv("COMMAND_DENIAL_MODE", "silent")
+	os.Setenv("COMMAND_DENIAL_GUILDS", "{\"123\":\"react\",\"456\":\"DM\"}")
+	defer os.Unsetenv("COMMAND_DENIAL_MODE")
+	defer os.Unsetenv("COMMAND_DENIAL_GUILDS")
+
+	config, err := loadDenialConfig()
+	if err != nil {
+		t.Fatalf("loadDenialConfig: FAILED, unexpected error %v", err)
+	}
+
+	testCases := []TestDenialModeItem{
+		{"123", denialReact},
+		{"456", denialDM},
+		{"789", denialSilent},
+		{"", denialSilent},
+	}
+
+	for _, test := range testCases {
+		if mode := config.modeFor(test.guild); mode != test.result {
+			t.Errorf("modeFor with args %v: FAILED, expected %v but got %v", test.guild, test.result, mode)
+		}
+	}
+
+	if mode := (denialConfig{}).modeFor("123"); mode != denialReply {
+		t.Errorf("modeFor with empty config: FAILED, expected %v but got %v", denialReply, mode)
+	}
+}
+
+func TestDenialConfigInvalid(t *testing.T) {
+
+	os.Setenv("COMMAND_DENIAL_GUILDS", "{\"123\":\"shout\"}")
+	defer os.Unsetenv("COMMAND_DENIAL_GUILDS")
+
+	if _, err := loadDenialConfig(); err == nil {
+		t.Errorf("loadDenialConfig with invalid mode: FAILED, expected an error")
+	}
+}
+
+type TestPermissionsItem struct {
+	perms    int64
+	required int64
+	result   bool
+}
+
+func TestHasPermissions(t *testing.T) {
+
+	testCases := []TestPermissionsItem{
+		{0, discordgo.PermissionManageServer, false},
+		{discordgo.PermissionManageServer, discordgo.PermissionManageServer, true},
+		{discordgo.PermissionManageServer | discordgo.PermissionSendMessages, discordgo.PermissionManageServer, true},
+		{discordgo.PermissionManageServer, discordgo.PermissionManageServer | discordgo.PermissionBanMembers, false},
+		{discordgo.PermissionManageServer, discordgo.PermissionAdministrator, false},
+		{discordgo.PermissionAdministrator, discordgo.PermissionManageServer | discordgo.PermissionBanMembers, true},
+	}
+
+	for _, test := range testCases {
+		if res := hasPermissions(test.perms, test.required); res != test.result {
+			t.Errorf("hasPermissions with args %v %v: FAILED, expected %v but got %v", test.perms, test.required, test.result, res)
+		}
+	}
+}
+
+// testFlags enables every flag for the users it lists
+type testFlags map[string]bool
+
+func (f testFlags) IsFeatureEnabled(featureKey string, userContext entities.UserContext) (bool, error) {
+	return f[userContext.ID], nil
+}
+
+func TestFeatureFlagWithoutRoles(t *testing.T) {
+
+	ctx := context.Background()
+
+	if !getFeatureFlagState(ctx, testFlags{"4001": true}, "4001", nil, flagReminder) {
+		t.Errorf("getFeatureFlagState without roles: FAILED, expected the flag to be enabled for the user")
+	}
+	if getFeatureFlagState(ctx, testFlags{"4001": true}, "4002", nil, flagReminder) {
+		t.Errorf("getFeatureFlagState without roles: FAILED, expected the flag to be disabled for another user")
+	}
+}
+
+// testDiscordAPI answers every Discord API request with an empty object, recording the
+// paths requested
+type testDiscordAPI struct {
+	paths []string
+}
+
+func (a *testDiscordAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	a.paths = append(a.paths, req.Method+" "+req.URL.Path)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+func TestReminderCommandInDM(t *testing.T) {
+
+	for _, flags := range []FeatureFlags{nil, testFlags{}} {
+		api := &testDiscordAPI{}
+		session, _ := discordgo.New("")
+		session.Client = &http.Client{Transport: api}
+		session.State.User = &discordgo.User{ID: "9001"}
+
+		store := newMemoryReminderStore()
+		bot := &botService{
+			flags:     flags,
+			reminders: store,
+			settings:  newUserSettingsStore(gosmosdb.NewMemoryDatabase()),
+			denial:    denialConfig{defaultMode: denialSilent},
+		}
+		sent := time.Now().Truncate(time.Millisecond)
+
+		bot.MessageRespond(session, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ID:        "3001",
+			ChannelID: "2001",
+			Content:   "!remindme stretch 1h",
+			Timestamp: sent,
+			Author:    &discordgo.User{ID: "4001", Username: "chris"},
+		}})
+
+		reminders, err := store.ListByCreator(context.Background(), "4001", sent)
+		if err != nil {
+			t.Fatalf("ListByCreator: FAILED, unexpected error %v", err)
+		}
+		if len(reminders) != 1 || strings.TrimSpace(reminders[0].Message) != "stretch" {
+			t.Errorf("remindme in a DM with flags %v: FAILED, expected a reminder to stretch but got %v", flags, reminders)
+		}
+		if len(api.paths) != 1 || !strings.HasSuffix(api.paths[0], "/channels/2001/messages") {
+			t.Errorf("remindme in a DM with flags %v: FAILED, expected a reply in the DM but got %v", flags, api.paths)
+		}
+	}
+}
@@ -1,13 +1,221 @@
+// Package gosmosdb is a small typed repository layer over Mongo (and Cosmos DB's Mongo
+// API), with an in-memory implementation for tests and running without a database.
 package gosmosdb
 
 import (
 	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrNotFound is returned by FindOne when no document matches the filter
+var ErrNotFound = errors.New("gosmosdb: document not found")
+
+// ErrDuplicate is returned by Insert when a document with the same _id already exists
+var ErrDuplicate = errors.New("gosmosdb: duplicate document")
+
+// Operator is a comparison applied to a single field
+type Operator string
+
+const (
+	OpEq  Operator = "$eq"
+	OpNe  Operator = "$ne"
+	OpGt  Operator = "$gt"
+	OpGte Operator = "$gte"
+	OpLt  Operator = "$lt"
+	OpLte Operator = "$lte"
+	OpIn  Operator = "$in"
 )
 
-type DbReader interface {
-	Find(ctx context.Context, filter interface{}) (result []interface{}, err error)
+// Condition compares a document field with a value
+type Condition struct {
+	Field string
+	Op    Operator
+	Value interface{}
+}
+
+// Filter matches documents where every condition is true. An empty filter matches
+// every document.
+type Filter []Condition
+
+// Where builds a filter from conditions
+func Where(conditions ...Condition) Filter {
+	return Filter(conditions)
+}
+
+func Eq(field string, value interface{}) Condition  { return Condition{field, OpEq, value} }
+func Ne(field string, value interface{}) Condition  { return Condition{field, OpNe, value} }
+func Gt(field string, value interface{}) Condition  { return Condition{field, OpGt, value} }
+func Gte(field string, value interface{}) Condition { return Condition{field, OpGte, value} }
+func Lt(field string, value interface{}) Condition  { return Condition{field, OpLt, value} }
+func Lte(field string, value interface{}) Condition { return Condition{field, OpLte, value} }
+func In(field string, values ...interface{}) Condition {
+	return Condition{field, OpIn, values}
+}
+
+// Update is the set of fields to overwrite on matching documents. A field set to Unset is
+// removed instead.
+type Update map[string]interface{}
+
+type unset struct{}
+
+// Unset removes a field when used as its value in an Update
+var Unset = unset{}
+
+// split divides the update into the fields to set and the fields to remove
+func (u Update) split() (bson.M, bson.M) {
+	set, remove := bson.M{}, bson.M{}
+	for k, v := range u {
+		if v == Unset {
+			remove[k] = ""
+		} else {
+			set[k] = v
+		}
+	}
+	return set, remove
+}
+
+// Sort orders results by a field
+type Sort struct {
+	Field      string
+	Descending bool
+}
+
+// FindOptions controls ordering and paging of results
+type FindOptions struct {
+	Sort  []Sort
+	Limit int64
+}
+
+// FindOption configures a Find call
+type FindOption func(*FindOptions)
+
+// SortBy orders results by the field, applied after any earlier sorts
+func SortBy(field string, descending bool) FindOption {
+	return func(o *FindOptions) {
+		o.Sort = append(o.Sort, Sort{Field: field, Descending: descending})
+	}
+}
+
+// Limit caps the number of results returned
+func Limit(n int64) FindOption {
+	return func(o *FindOptions) {
+		o.Limit = n
+	}
+}
+
+// Index is an ascending index over one or more fields, named so creating it again is a
+// no-op
+type Index struct {
+	Name   string
+	Fields []string
+}
+
+// Collection is the untyped storage a Repository reads and writes BSON documents through
+type Collection interface {
+	Find(ctx context.Context, filter Filter, opts FindOptions) ([]bson.Raw, error)
+	Insert(ctx context.Context, document interface{}) error
+	Update(ctx context.Context, filter Filter, update Update) (int64, error)
+	// Upsert updates the first matching document, or inserts one made from the filter's Eq
+	// conditions and the update if none match, in a single write
+	Upsert(ctx context.Context, filter Filter, update Update) error
+	Delete(ctx context.Context, filter Filter) (int64, error)
+	Count(ctx context.Context, filter Filter) (int64, error)
+	// EnsureIndex creates the index if it doesn't already exist
+	EnsureIndex(ctx context.Context, index Index) error
+}
+
+// Database hands out collections by name and owns the underlying connection
+type Database interface {
+	Collection(name string) Collection
+	// Ping checks the database is reachable
+	Ping(ctx context.Context) error
+	// Close releases the connection, after which the database can't be used
+	Close(ctx context.Context) error
+}
+
+type DbReader[T any] interface {
+	Find(ctx context.Context, filter Filter, opts ...FindOption) ([]T, error)
+	FindOne(ctx context.Context, filter Filter, opts ...FindOption) (T, error)
+	Count(ctx context.Context, filter Filter) (int64, error)
+}
+
+type DbWriter[T any] interface {
+	Insert(ctx context.Context, document T) error
+	Update(ctx context.Context, filter Filter, update Update) (int64, error)
+	Upsert(ctx context.Context, filter Filter, update Update) error
+	Delete(ctx context.Context, filter Filter) (int64, error)
+}
+
+// Repository reads and writes documents of type T, mapped to BSON with the bson tags on T
+type Repository[T any] interface {
+	DbReader[T]
+	DbWriter[T]
+}
+
+// NewRepository returns a Repository storing T in the collection
+func NewRepository[T any](c Collection) Repository[T] {
+	return &repository[T]{c: c}
+}
+
+type repository[T any] struct {
+	c Collection
+}
+
+func (r *repository[T]) Find(ctx context.Context, filter Filter, opts ...FindOption) ([]T, error) {
+	var o FindOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	raws, err := r.c.Find(ctx, filter, o)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(raws))
+	for _, raw := range raws {
+		var doc T
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		results = append(results, doc)
+	}
+
+	return results, nil
+}
+
+func (r *repository[T]) FindOne(ctx context.Context, filter Filter, opts ...FindOption) (T, error) {
+	var zero T
+
+	results, err := r.Find(ctx, filter, append(opts, Limit(1))...)
+	if err != nil {
+		return zero, err
+	}
+	if len(results) == 0 {
+		return zero, ErrNotFound
+	}
+
+	return results[0], nil
+}
+
+func (r *repository[T]) Insert(ctx context.Context, document T) error {
+	return r.c.Insert(ctx, document)
+}
+
+func (r *repository[T]) Update(ctx context.Context, filter Filter, update Update) (int64, error) {
+	return r.c.Update(ctx, filter, update)
+}
+
+func (r *repository[T]) Upsert(ctx context.Context, filter Filter, update Update) error {
+	return r.c.Upsert(ctx, filter, update)
+}
+
+func (r *repository[T]) Delete(ctx context.Context, filter Filter) (int64, error) {
+	return r.c.Delete(ctx, filter)
 }
 
-type DbWriter interface {
-	InsertOne(ctx context.Context, document interface{}) (result interface{}, err error)
+func (r *repository[T]) Count(ctx context.Context, filter Filter) (int64, error) {
+	return r.c.Count(ctx, filter)
 }
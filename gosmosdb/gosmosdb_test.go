@@ -0,0 +1,263 @@
+package gosmosdb
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testDocument struct {
+	ID      string    `bson:"id"`
+	Owner   string    `bson:"owner"`
+	Count   int       `bson:"count"`
+	Done    bool      `bson:"done"`
+	Created time.Time `bson:"created"`
+}
+
+type TestFilterItem struct {
+	filter Filter
+	result []string
+}
+
+func seedRepository(t *testing.T, db Database) Repository[testDocument] {
+	repo := NewRepository[testDocument](db.Collection("docs"))
+	base := time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	docs := []testDocument{
+		{"a", "chris", 3, false, base},
+		{"b", "sarah", 1, true, base.Add(time.Hour)},
+		{"c", "chris", 2, true, base.Add(2 * time.Hour)},
+	}
+	for _, d := range docs {
+		if err := repo.Insert(context.Background(), d); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+
+	return repo
+}
+
+func ids(docs []testDocument) []string {
+	var result []string
+	for _, d := range docs {
+		result = append(result, d.ID)
+	}
+	return result
+}
+
+func TestMemoryFind(t *testing.T) {
+
+	repo := seedRepository(t, NewMemoryDatabase())
+	base := time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	testCases := []TestFilterItem{
+		{Where(), []string{"a", "b", "c"}},
+		{Where(Eq("owner", "chris")), []string{"a", "c"}},
+		{Where(Ne("owner", "chris")), []string{"b"}},
+		{Where(Eq("done", true), Eq("owner", "chris")), []string{"c"}},
+		{Where(Gt("count", 1)), []string{"a", "c"}},
+		{Where(Gte("count", 1), Lt("count", 3)), []string{"b", "c"}},
+		{Where(Gt("created", base), Lte("created", base.Add(time.Hour))), []string{"b"}},
+		{Where(In("id", "a", "b")), []string{"a", "b"}},
+		{Where(Ne("missing", true)), []string{"a", "b", "c"}},
+		{Where(Eq("missing", nil)), []string{"a", "b", "c"}},
+	}
+
+	for _, test := range testCases {
+		docs, err := repo.Find(context.Background(), test.filter)
+		if err != nil {
+			t.Errorf("Find with filter %v: FAILED, unexpected error %v", test.filter, err)
+			continue
+		}
+
+		if got := ids(docs); !equalStrings(got, test.result) {
+			t.Errorf("Find with filter %v: FAILED, expected %v but got %v", test.filter, test.result, got)
+		}
+	}
+}
+
+func TestMemorySortAndLimit(t *testing.T) {
+
+	repo := seedRepository(t, NewMemoryDatabase())
+	ctx := context.Background()
+
+	docs, _ := repo.Find(ctx, Where(), SortBy("count", false))
+	if got := ids(docs); !equalStrings(got, []string{"b", "c", "a"}) {
+		t.Errorf("Find sorted by count: FAILED, expected [b c a] but got %v", got)
+	}
+
+	docs, _ = repo.Find(ctx, Where(), SortBy("owner", false), SortBy("created", true), Limit(2))
+	if got := ids(docs); !equalStrings(got, []string{"c", "a"}) {
+		t.Errorf("Find sorted by owner then created: FAILED, expected [c a] but got %v", got)
+	}
+
+	doc, err := repo.FindOne(ctx, Where(Eq("owner", "sarah")))
+	if err != nil || doc.ID != "b" {
+		t.Errorf("FindOne: FAILED, expected b but got %v (%v)", doc.ID, err)
+	}
+
+	if _, err := repo.FindOne(ctx, Where(Eq("owner", "dave"))); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindOne with no match: FAILED, expected ErrNotFound but got %v", err)
+	}
+}
+
+func TestMemoryWrites(t *testing.T) {
+
+	repo := seedRepository(t, NewMemoryDatabase())
+	ctx := context.Background()
+
+	n, err := repo.Update(ctx, Where(Eq("owner", "chris")), Update{"done": true, "note": "updated"})
+	if err != nil || n != 2 {
+		t.Fatalf("Update: FAILED, expected 2 matches but got %d (%v)", n, err)
+	}
+
+	if n, _ := repo.Count(ctx, Where(Eq("done", true))); n != 3 {
+		t.Errorf("Count after update: FAILED, expected 3 but got %d", n)
+	}
+	if n, _ := repo.Count(ctx, Where(Eq("note", "updated"))); n != 2 {
+		t.Errorf("Count of added field: FAILED, expected 2 but got %d", n)
+	}
+
+	if _, err := repo.Update(ctx, Where(Eq("id", "c")), Update{"note": Unset, "gone": Unset}); err != nil {
+		t.Fatalf("Update with Unset: FAILED, unexpected error %v", err)
+	}
+	if n, _ := repo.Count(ctx, Where(Eq("note", "updated"))); n != 1 {
+		t.Errorf("Count of unset field: FAILED, expected 1 but got %d", n)
+	}
+
+	n, err = repo.Delete(ctx, Where(Eq("id", "a")))
+	if err != nil || n != 1 {
+		t.Fatalf("Delete: FAILED, expected 1 deletion but got %d (%v)", n, err)
+	}
+
+	if n, _ := repo.Count(ctx, Where()); n != 2 {
+		t.Errorf("Count after delete: FAILED, expected 2 but got %d", n)
+	}
+}
+
+func TestFileDatabase(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gosmosdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "db.json")
+	ctx := context.Background()
+
+	db, err := OpenFileDatabase(path)
+	if err != nil {
+		t.Fatalf("OpenFileDatabase: FAILED, unexpected error %v", err)
+	}
+	repo := seedRepository(t, db)
+	repo.Update(ctx, Where(Eq("id", "a")), Update{"done": true})
+
+	reopened, err := OpenFileDatabase(path)
+	if err != nil {
+		t.Fatalf("OpenFileDatabase: FAILED, unexpected error %v", err)
+	}
+
+	docs, err := NewRepository[testDocument](reopened.Collection("docs")).Find(ctx, Where(Eq("done", true)), SortBy("id", false))
+	if err != nil {
+		t.Fatalf("Find: FAILED, unexpected error %v", err)
+	}
+	if got := ids(docs); !equalStrings(got, []string{"a", "b", "c"}) {
+		t.Errorf("reopened database: FAILED, expected [a b c] but got %v", got)
+	}
+	if !docs[0].Created.Equal(time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("reopened database: FAILED, expected created time to survive but got %v", docs[0].Created)
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryInsertDuplicateID(t *testing.T) {
+
+	type keyed struct {
+		Name  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+
+	repo := NewRepository[keyed](NewMemoryDatabase().Collection("keyed"))
+	ctx := context.Background()
+
+	if err := repo.Insert(ctx, keyed{"a", 1}); err != nil {
+		t.Fatalf("Insert: FAILED, unexpected error %v", err)
+	}
+	if err := repo.Insert(ctx, keyed{"a", 2}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Insert with a duplicate _id: FAILED, expected %v but got %v", ErrDuplicate, err)
+	}
+	if err := repo.Insert(ctx, keyed{"b", 3}); err != nil {
+		t.Errorf("Insert: FAILED, unexpected error %v", err)
+	}
+}
+
+func TestMemoryMatchesArrayElements(t *testing.T) {
+
+	type tagged struct {
+		ID   string   `bson:"id"`
+		Tags []string `bson:"tags"`
+	}
+
+	repo := NewRepository[tagged](NewMemoryDatabase().Collection("tagged"))
+	ctx := context.Background()
+
+	repo.Insert(ctx, tagged{"a", []string{"red", "blue"}})
+	repo.Insert(ctx, tagged{"b", []string{"green"}})
+	repo.Insert(ctx, tagged{"c", nil})
+
+	tests := []struct {
+		filter   Filter
+		expected int64
+	}{
+		{Where(Eq("tags", "blue")), 1},
+		{Where(Ne("tags", "blue")), 2},
+		{Where(In("tags", "green", "red")), 2},
+		{Where(Eq("tags", []string{"green"})), 1},
+	}
+
+	for _, tc := range tests {
+		if n, _ := repo.Count(ctx, tc.filter); n != tc.expected {
+			t.Errorf("Count %v: FAILED, expected %d but got %d", tc.filter, tc.expected, n)
+		}
+	}
+}
+
+func TestMemoryUpsert(t *testing.T) {
+
+	repo := seedRepository(t, NewMemoryDatabase())
+	ctx := context.Background()
+
+	if err := repo.Upsert(ctx, Where(Eq("owner", "chris")), Update{"count": 9}); err != nil {
+		t.Fatalf("Upsert: FAILED, unexpected error %v", err)
+	}
+	if n, _ := repo.Count(ctx, Where(Eq("count", 9))); n != 1 {
+		t.Errorf("Upsert of a match: FAILED, expected 1 document updated but got %d", n)
+	}
+
+	if err := repo.Upsert(ctx, Where(Eq("id", "d"), Gt("count", 0)), Update{"owner": "dave", "count": 4}); err != nil {
+		t.Fatalf("Upsert: FAILED, unexpected error %v", err)
+	}
+	doc, err := repo.FindOne(ctx, Where(Eq("id", "d")))
+	if err != nil || doc.Owner != "dave" || doc.Count != 4 {
+		t.Errorf("Upsert without a match: FAILED, expected d owned by dave with count 4 but got %+v (%v)", doc, err)
+	}
+	if n, _ := repo.Count(ctx, Where()); n != 4 {
+		t.Errorf("Count after upsert: FAILED, expected 4 but got %d", n)
+	}
+}
@@ -0,0 +1,525 @@
+package gosmosdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryDatabase keeps documents in memory. It evaluates filters the same way Mongo does
+// for the operators gosmosdb supports, so it can stand in for Mongo in tests. When opened
+// with OpenFileDatabase every write is also saved to disk.
+type MemoryDatabase struct {
+	mu          sync.Mutex
+	collections map[string][]bson.Raw
+
+	path   string
+	saveMu sync.Mutex
+}
+
+// NewMemoryDatabase returns an empty in-memory database
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{collections: make(map[string][]bson.Raw)}
+}
+
+// OpenFileDatabase loads the database saved at path, if there is one, and saves every
+// change back to it as extended JSON.
+func OpenFileDatabase(path string) (*MemoryDatabase, error) {
+	db := NewMemoryDatabase()
+	db.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := bson.UnmarshalExtJSON(data, true, &db.collections); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+
+	return db, nil
+}
+
+func (db *MemoryDatabase) Collection(name string) Collection {
+	return &memoryCollection{db: db, name: name}
+}
+
+func (db *MemoryDatabase) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing as every write has already been saved
+func (db *MemoryDatabase) Close(ctx context.Context) error {
+	return nil
+}
+
+// save writes to a temporary file and renames it so a crash never leaves a partial file
+func (db *MemoryDatabase) save() error {
+	if db.path == "" {
+		return nil
+	}
+
+	db.saveMu.Lock()
+	defer db.saveMu.Unlock()
+
+	db.mu.Lock()
+	data, err := bson.MarshalExtJSONIndent(db.collections, true, false, "", "  ")
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(db.path), filepath.Base(db.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), db.path)
+}
+
+type memoryCollection struct {
+	db   *MemoryDatabase
+	name string
+}
+
+func (m *memoryCollection) Find(ctx context.Context, filter Filter, opts FindOptions) ([]bson.Raw, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	type match struct {
+		raw bson.Raw
+		doc bson.M
+	}
+
+	var matches []match
+	for _, raw := range m.db.collections[m.name] {
+		doc, ok, err := matchDocument(raw, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, match{raw, doc})
+		}
+	}
+
+	if len(opts.Sort) > 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			for _, s := range opts.Sort {
+				a, _ := lookupField(matches[i].doc, s.Field)
+				b, _ := lookupField(matches[j].doc, s.Field)
+				c := compareSortValues(a, b)
+				if c == 0 {
+					continue
+				}
+				if s.Descending {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	if opts.Limit > 0 && int64(len(matches)) > opts.Limit {
+		matches = matches[:opts.Limit]
+	}
+
+	results := make([]bson.Raw, 0, len(matches))
+	for _, match := range matches {
+		results = append(results, match.raw)
+	}
+
+	return results, nil
+}
+
+func (m *memoryCollection) Insert(ctx context.Context, document interface{}) error {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	// _id is unique, as it is in Mongo
+	id, idErr := bson.Raw(raw).LookupErr("_id")
+
+	m.db.mu.Lock()
+	if idErr == nil {
+		for _, existing := range m.db.collections[m.name] {
+			if v, err := existing.LookupErr("_id"); err == nil && v.Equal(id) {
+				m.db.mu.Unlock()
+				return fmt.Errorf("%w: _id %v", ErrDuplicate, id)
+			}
+		}
+	}
+	m.db.collections[m.name] = append(m.db.collections[m.name], raw)
+	m.db.mu.Unlock()
+
+	return m.db.save()
+}
+
+func (m *memoryCollection) Update(ctx context.Context, filter Filter, update Update) (int64, error) {
+	m.db.mu.Lock()
+	matched, err := m.updateLocked(filter, update, -1)
+	m.db.mu.Unlock()
+
+	if err != nil || matched == 0 {
+		return matched, err
+	}
+
+	return matched, m.db.save()
+}
+
+func (m *memoryCollection) Upsert(ctx context.Context, filter Filter, update Update) error {
+	m.db.mu.Lock()
+
+	matched, err := m.updateLocked(filter, update, 1)
+	if err == nil && matched == 0 {
+		// as in Mongo, the new document starts from the filter's equality conditions
+		var doc bson.D
+		for _, cond := range filter {
+			if cond.Op == OpEq {
+				doc = append(doc, bson.E{Key: cond.Field, Value: cond.Value})
+			}
+		}
+		var raw bson.Raw
+		if raw, err = bson.Marshal(doc); err == nil {
+			if raw, err = applyUpdate(raw, update); err == nil {
+				m.db.collections[m.name] = append(m.db.collections[m.name], raw)
+			}
+		}
+	}
+
+	m.db.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return m.db.save()
+}
+
+// updateLocked applies the update to at most limit matching documents, or all of them if
+// limit is negative. The caller holds the lock.
+func (m *memoryCollection) updateLocked(filter Filter, update Update, limit int64) (int64, error) {
+	var matched int64
+	docs := m.db.collections[m.name]
+	for i, raw := range docs {
+		if matched == limit {
+			break
+		}
+		_, ok, err := matchDocument(raw, filter)
+		if err != nil {
+			return matched, err
+		}
+		if !ok {
+			continue
+		}
+
+		updated, err := applyUpdate(raw, update)
+		if err != nil {
+			return matched, err
+		}
+		docs[i] = updated
+		matched++
+	}
+
+	return matched, nil
+}
+
+func (m *memoryCollection) Delete(ctx context.Context, filter Filter) (int64, error) {
+	m.db.mu.Lock()
+
+	var deleted int64
+	var kept []bson.Raw
+	for _, raw := range m.db.collections[m.name] {
+		_, ok, err := matchDocument(raw, filter)
+		if err != nil {
+			m.db.mu.Unlock()
+			return 0, err
+		}
+		if ok {
+			deleted++
+		} else {
+			kept = append(kept, raw)
+		}
+	}
+	m.db.collections[m.name] = kept
+
+	m.db.mu.Unlock()
+
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	return deleted, m.db.save()
+}
+
+func (m *memoryCollection) Count(ctx context.Context, filter Filter) (int64, error) {
+	results, err := m.Find(ctx, filter, FindOptions{})
+	return int64(len(results)), err
+}
+
+// EnsureIndex does nothing, every query scans the whole collection
+func (m *memoryCollection) EnsureIndex(ctx context.Context, index Index) error {
+	return nil
+}
+
+func applyUpdate(raw bson.Raw, update Update) (bson.Raw, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(update))
+	for k := range update {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		set := false
+		for i := range doc {
+			if doc[i].Key == k {
+				if update[k] == Unset {
+					doc = append(doc[:i], doc[i+1:]...)
+				} else {
+					doc[i].Value = update[k]
+				}
+				set = true
+				break
+			}
+		}
+		if !set && update[k] != Unset {
+			doc = append(doc, bson.E{Key: k, Value: update[k]})
+		}
+	}
+
+	return bson.Marshal(doc)
+}
+
+func matchDocument(raw bson.Raw, filter Filter) (bson.M, bool, error) {
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+
+	for _, cond := range filter {
+		ok, err := matchCondition(doc, cond)
+		if err != nil || !ok {
+			return doc, false, err
+		}
+	}
+
+	return doc, true, nil
+}
+
+func matchCondition(doc bson.M, cond Condition) (bool, error) {
+	value, exists := lookupField(doc, cond.Field)
+
+	target, err := normalise(cond.Value)
+	if err != nil {
+		return false, err
+	}
+
+	switch cond.Op {
+	case OpEq:
+		return equalValues(value, exists, target), nil
+	case OpNe:
+		return !equalValues(value, exists, target), nil
+	case OpIn:
+		values, ok := target.(primitive.A)
+		if !ok {
+			return false, fmt.Errorf("gosmosdb: $in on %s needs a list of values", cond.Field)
+		}
+		for _, v := range values {
+			if equalValues(value, exists, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case OpGt, OpGte, OpLt, OpLte:
+		if !exists {
+			return false, nil
+		}
+		c, ok := compareValues(value, target)
+		if !ok {
+			return false, nil
+		}
+		switch cond.Op {
+		case OpGt:
+			return c > 0, nil
+		case OpGte:
+			return c >= 0, nil
+		case OpLt:
+			return c < 0, nil
+		default:
+			return c <= 0, nil
+		}
+	default:
+		return false, fmt.Errorf("gosmosdb: unsupported operator %s", cond.Op)
+	}
+}
+
+// normalise round trips a value through BSON so it compares like the stored documents,
+// e.g. time.Time becomes primitive.DateTime and slices become primitive.A.
+func normalise(v interface{}) (interface{}, error) {
+	raw, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bson.M
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+
+	return out["v"], nil
+}
+
+func lookupField(doc bson.M, field string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		switch d := current.(type) {
+		case bson.M:
+			v, ok := d[part]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case bson.D:
+			found := false
+			for _, e := range d {
+				if e.Key == part {
+					current = e.Value
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func equalValues(value interface{}, exists bool, target interface{}) bool {
+	if !exists || value == nil {
+		return target == nil
+	}
+
+	// as in Mongo, a single value matches an array that contains it
+	if values, ok := value.(primitive.A); ok {
+		if _, ok := target.(primitive.A); !ok {
+			for _, v := range values {
+				if equalValues(v, true, target) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	if c, ok := compareValues(value, target); ok {
+		return c == 0
+	}
+
+	return reflect.DeepEqual(value, target)
+}
+
+// compareValues orders two values of the same BSON kind, reporting false if they can't
+// be compared.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			switch {
+			case af < bf:
+				return -1, true
+			case af > bf:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+
+	switch av := a.(type) {
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), true
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0, true
+			case bv:
+				return -1, true
+			}
+			return 1, true
+		}
+	case primitive.DateTime:
+		if bv, ok := b.(primitive.DateTime); ok {
+			switch {
+			case av < bv:
+				return -1, true
+			case av > bv:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+// compareSortValues orders values for sorting, putting missing and incomparable values first
+func compareSortValues(a interface{}, b interface{}) int {
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+	switch {
+	case a == nil && b != nil:
+		return -1
+	case a != nil && b == nil:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
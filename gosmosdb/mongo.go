@@ -0,0 +1,240 @@
+package gosmosdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/honeycombio/beeline-go"
+	"github.com/honeycombio/beeline-go/trace"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// NewMongoDatabase returns a Database backed by the Mongo database. Closing it
+// disconnects the database's client.
+func NewMongoDatabase(db *mongo.Database) Database {
+	return &mongoDatabase{db: db}
+}
+
+type mongoDatabase struct {
+	db *mongo.Database
+}
+
+func (m *mongoDatabase) Collection(name string) Collection {
+	return &mongoCollection{c: m.db.Collection(name)}
+}
+
+func (m *mongoDatabase) Ping(ctx context.Context) error {
+	return m.db.Client().Ping(ctx, readpref.Primary())
+}
+
+func (m *mongoDatabase) Close(ctx context.Context) error {
+	return m.db.Client().Disconnect(ctx)
+}
+
+type mongoCollection struct {
+	c *mongo.Collection
+}
+
+// filterDocument converts a Filter into a Mongo query, grouping conditions on the same
+// field so ranges like $gt and $lt apply together.
+func filterDocument(filter Filter) bson.D {
+	query := bson.D{}
+	fields := make(map[string]int)
+
+	for _, cond := range filter {
+		i, ok := fields[cond.Field]
+		if !ok {
+			query = append(query, bson.E{Key: cond.Field, Value: bson.D{}})
+			i = len(query) - 1
+			fields[cond.Field] = i
+		}
+		query[i].Value = append(query[i].Value.(bson.D), bson.E{Key: string(cond.Op), Value: cond.Value})
+	}
+
+	return query
+}
+
+// updateDocument converts an Update into Mongo's $set and $unset operators, leaving out
+// either one when it would be empty as Mongo rejects that
+func updateDocument(update Update) bson.M {
+	set, remove := update.split()
+	doc := bson.M{}
+	if len(set) > 0 {
+		doc["$set"] = set
+	}
+	if len(remove) > 0 {
+		doc["$unset"] = remove
+	}
+	return doc
+}
+
+func (m *mongoCollection) addFields(span *trace.Span, op string) {
+	span.AddField("mongo."+op+".collection", m.c.Name())
+	span.AddField("mongo."+op+".database", m.c.Database().Name())
+}
+
+func (m *mongoCollection) Find(ctx context.Context, filter Filter, opts FindOptions) ([]bson.Raw, error) {
+	ctx, span := beeline.StartSpan(ctx, "mongo.find")
+	defer span.Send()
+
+	query := filterDocument(filter)
+	m.addFields(span, "find")
+	span.AddField("mongo.find.query", query)
+
+	findOptions := options.Find()
+	if len(opts.Sort) > 0 {
+		sort := bson.D{}
+		for _, s := range opts.Sort {
+			order := 1
+			if s.Descending {
+				order = -1
+			}
+			sort = append(sort, bson.E{Key: s.Field, Value: order})
+		}
+		findOptions.SetSort(sort)
+	}
+	if opts.Limit > 0 {
+		findOptions.SetLimit(opts.Limit)
+	}
+
+	cursor, err := m.c.Find(ctx, query, findOptions)
+	if err != nil {
+		span.AddField("mongo.find.error", err)
+		return nil, err
+	}
+
+	var results []bson.Raw
+	if err = cursor.All(ctx, &results); err != nil {
+		span.AddField("mongo.find.error", err)
+		return nil, err
+	}
+
+	span.AddField("mongo.find.results.Count", len(results))
+
+	return results, nil
+}
+
+func (m *mongoCollection) Insert(ctx context.Context, document interface{}) error {
+	ctx, span := beeline.StartSpan(ctx, "mongo.insert")
+	defer span.Send()
+
+	m.addFields(span, "insert")
+
+	res, err := m.c.InsertOne(ctx, document)
+	if err != nil {
+		span.AddField("mongo.insert.error", err)
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: %v", ErrDuplicate, err)
+		}
+		return err
+	}
+
+	span.AddField("mongo.insert.id", res.InsertedID)
+
+	return nil
+}
+
+func (m *mongoCollection) Update(ctx context.Context, filter Filter, update Update) (int64, error) {
+	ctx, span := beeline.StartSpan(ctx, "mongo.update")
+	defer span.Send()
+
+	query := filterDocument(filter)
+	m.addFields(span, "update")
+	span.AddField("mongo.update.query", query)
+
+	res, err := m.c.UpdateMany(ctx, query, updateDocument(update))
+	if err != nil {
+		span.AddField("mongo.update.error", err)
+		return 0, err
+	}
+
+	span.AddField("mongo.update.matched", res.MatchedCount)
+
+	return res.MatchedCount, nil
+}
+
+func (m *mongoCollection) Upsert(ctx context.Context, filter Filter, update Update) error {
+	ctx, span := beeline.StartSpan(ctx, "mongo.upsert")
+	defer span.Send()
+
+	query := filterDocument(filter)
+	m.addFields(span, "upsert")
+	span.AddField("mongo.upsert.query", query)
+
+	res, err := m.c.UpdateOne(ctx, query, updateDocument(update), options.Update().SetUpsert(true))
+	if err != nil {
+		span.AddField("mongo.upsert.error", err)
+		return err
+	}
+
+	span.AddField("mongo.upsert.matched", res.MatchedCount)
+	span.AddField("mongo.upsert.upserted", res.UpsertedCount)
+
+	return nil
+}
+
+func (m *mongoCollection) Delete(ctx context.Context, filter Filter) (int64, error) {
+	ctx, span := beeline.StartSpan(ctx, "mongo.delete")
+	defer span.Send()
+
+	query := filterDocument(filter)
+	m.addFields(span, "delete")
+	span.AddField("mongo.delete.query", query)
+
+	res, err := m.c.DeleteMany(ctx, query)
+	if err != nil {
+		span.AddField("mongo.delete.error", err)
+		return 0, err
+	}
+
+	span.AddField("mongo.delete.deleted", res.DeletedCount)
+
+	return res.DeletedCount, nil
+}
+
+func (m *mongoCollection) Count(ctx context.Context, filter Filter) (int64, error) {
+	ctx, span := beeline.StartSpan(ctx, "mongo.count")
+	defer span.Send()
+
+	query := filterDocument(filter)
+	m.addFields(span, "count")
+	span.AddField("mongo.count.query", query)
+
+	n, err := m.c.CountDocuments(ctx, query)
+	if err != nil {
+		span.AddField("mongo.count.error", err)
+		return 0, err
+	}
+
+	span.AddField("mongo.count.result", n)
+
+	return n, nil
+}
+
+func (m *mongoCollection) EnsureIndex(ctx context.Context, index Index) error {
+	ctx, span := beeline.StartSpan(ctx, "mongo.ensureIndex")
+	defer span.Send()
+
+	m.addFields(span, "ensureIndex")
+	span.AddField("mongo.ensureIndex.name", index.Name)
+	span.AddField("mongo.ensureIndex.fields", index.Fields)
+
+	keys := bson.D{}
+	for _, f := range index.Fields {
+		keys = append(keys, bson.E{Key: f, Value: 1})
+	}
+
+	_, err := m.c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(index.Name),
+	})
+	if err != nil {
+		span.AddField("mongo.ensureIndex.error", err)
+		return err
+	}
+
+	return nil
+}
@@ -73,7 +73,7 @@ func getMessageProps(me *MessageEvent) map[string]interface{} {
 	messageProps["message.AuthorID"] = me.Message.Author.ID
 	messageProps["message.AuthorUsername"] = me.Message.Author.Username
 	messageProps["message.MessageType"] = me.Message.Type
-	messageProps["message.RawContent"] = me.Message.Content
+	messageProps["message.ContentLength"] = len(me.Message.Content)
 	messageProps["message.MentionEveryone"] = me.Message.MentionEveryone
 	messageProps["message.MentionRoles"] = me.Message.MentionRoles
 
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+	"github.com/honeycombio/beeline-go"
+)
+
+// lease gives one instance of the bot the right to do something, like sending reminders,
+// until Expires. The holder renews it well before then, and if it stops another instance
+// can take it over.
+type lease struct {
+	Name    string    `json:"name" bson:"_id"`
+	Holder  string    `json:"holder" bson:"holder"`
+	Expires time.Time `json:"expires" bson:"expires"`
+}
+
+// leaseStore keeps leases in LEASE_COLLECTION (default leases) of the database
+type leaseStore struct {
+	repo gosmosdb.Repository[lease]
+}
+
+func newLeaseStore(db gosmosdb.Database) *leaseStore {
+	return &leaseStore{
+		repo: gosmosdb.NewRepository[lease](db.Collection(envOrDefault("LEASE_COLLECTION", "leases"))),
+	}
+}
+
+// Acquire takes or renews the lease for holder until now+ttl, reporting false if another
+// instance holds it. Each step is a single conditional write, so two instances can't
+// both succeed.
+func (s *leaseStore) Acquire(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (bool, error) {
+	expires := now.Add(ttl)
+
+	// renew our own lease
+	n, err := s.repo.Update(ctx, gosmosdb.Where(gosmosdb.Eq("_id", name), gosmosdb.Eq("holder", holder)), gosmosdb.Update{"expires": expires})
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+
+	// take over one that has lapsed
+	n, err = s.repo.Update(ctx, gosmosdb.Where(gosmosdb.Eq("_id", name), gosmosdb.Lt("expires", now)), gosmosdb.Update{"holder": holder, "expires": expires})
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+
+	// or create it the first time, losing if another instance got there first
+	err = s.repo.Insert(ctx, lease{Name: name, Holder: holder, Expires: expires})
+	if errors.Is(err, gosmosdb.ErrDuplicate) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Release gives up the lease so another instance can take it without waiting for it to
+// expire
+func (s *leaseStore) Release(ctx context.Context, name string, holder string) error {
+	_, err := s.repo.Update(ctx, gosmosdb.Where(gosmosdb.Eq("_id", name), gosmosdb.Eq("holder", holder)), gosmosdb.Update{"expires": time.Time{}})
+	return err
+}
+
+// instanceID names this process for leases and reminder claims
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "bot"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s", host, hex.EncodeToString(b))
+}
+
+// runAsLeader runs fn only while this instance holds the named lease, so just one
+// replica does the work at a time. The lease is renewed every third of ttl; if a renewal
+// fails fn's context is cancelled, and it starts again if the lease is won back.
+func runAsLeader(ctx context.Context, leases *leaseStore, name string, holder string, ttl time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	var stop func()
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+		release, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		leases.Release(release, name, holder)
+	}()
+
+	for {
+		leader := tryLease(ctx, leases, name, holder, ttl)
+
+		switch {
+		case leader && stop == nil:
+			stop = runInBackground(ctx, fn)
+		case !leader && stop != nil:
+			stop()
+			stop = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runInBackground starts fn, returning a function that cancels it and waits for it to
+// finish
+func runInBackground(ctx context.Context, fn func(ctx context.Context)) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func tryLease(ctx context.Context, leases *leaseStore, name string, holder string, ttl time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, ttl/3)
+	defer cancel()
+
+	ctx, span := beeline.StartSpan(ctx, "runAsLeader")
+	defer span.Send()
+
+	span.AddField("runAsLeader.lease", name)
+	span.AddField("runAsLeader.holder", holder)
+
+	ok, err := leases.Acquire(ctx, name, holder, ttl, time.Now())
+	if err != nil {
+		span.AddField("runAsLeader.error", err)
+		return false
+	}
+	span.AddField("runAsLeader.leader", ok)
+	return ok
+}
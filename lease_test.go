@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+func TestLeaseAcquire(t *testing.T) {
+
+	ctx := context.Background()
+	leases := newLeaseStore(gosmosdb.NewMemoryDatabase())
+	now := time.Now()
+
+	tests := []struct {
+		holder   string
+		at       time.Time
+		expected bool
+	}{
+		{"a", now, true},
+		{"b", now, false},
+		{"a", now.Add(20 * time.Second), true},
+		// a's renewal runs until now+50s
+		{"b", now.Add(40 * time.Second), false},
+		{"b", now.Add(time.Minute), true},
+		{"a", now.Add(time.Minute), false},
+	}
+
+	for _, tc := range tests {
+		ok, err := leases.Acquire(ctx, "scheduler", tc.holder, 30*time.Second, tc.at)
+		if err != nil {
+			t.Fatalf("Acquire: FAILED, unexpected error %v", err)
+		}
+		if ok != tc.expected {
+			t.Errorf("Acquire %v at %v: FAILED, expected %v but got %v", tc.holder, tc.at.Sub(now), tc.expected, ok)
+		}
+	}
+
+	if err := leases.Release(ctx, "scheduler", "b"); err != nil {
+		t.Fatalf("Release: FAILED, unexpected error %v", err)
+	}
+	if ok, _ := leases.Acquire(ctx, "scheduler", "a", 30*time.Second, now.Add(time.Minute)); !ok {
+		t.Errorf("Acquire after release: FAILED, expected a to take over straight away")
+	}
+}
+
+func TestRunAsLeader(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leases := newLeaseStore(gosmosdb.NewMemoryDatabase())
+
+	var running int32
+	work := func(ctx context.Context) {
+		atomic.AddInt32(&running, 1)
+		<-ctx.Done()
+		atomic.AddInt32(&running, -1)
+	}
+
+	done := make(chan struct{}, 2)
+	for _, holder := range []string{"a", "b"} {
+		go func(holder string) {
+			runAsLeader(ctx, leases, "scheduler", holder, 150*time.Millisecond, work)
+			done <- struct{}{}
+		}(holder)
+	}
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if n := atomic.LoadInt32(&running); n > 1 {
+			t.Fatalf("runAsLeader: FAILED, expected one instance running but got %d", n)
+		}
+	}
+	if n := atomic.LoadInt32(&running); n != 1 {
+		t.Errorf("runAsLeader: FAILED, expected one instance running but got %d", n)
+	}
+
+	cancel()
+	<-done
+	<-done
+	if n := atomic.LoadInt32(&running); n != 0 {
+		t.Errorf("runAsLeader: FAILED, expected work to stop with the context but %d still running", n)
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/chrislgardner/go-discord-bot/hnydiscordgo"
@@ -15,6 +17,10 @@ import (
 
 func main() {
 
+	if len(os.Args) > 1 {
+		os.Exit(runSubcommand(os.Args[1:]))
+	}
+
 	beeline.Init(beeline.Config{
 		WriteKey: os.Getenv("HONEYCOMB_KEY"),
 		Dataset:  os.Getenv("HONEYCOMB_DATASET"),
@@ -28,12 +34,62 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+
+	// The guild intent keeps the state cache populated with roles from GuildCreate and
+	// GuildRoleUpdate events. The member intent does the same for members, but it's
+	// privileged and Open fails unless it's enabled for the bot in the developer portal,
+	// so it's only asked for with DISCORD_MEMBERS_INTENT=true. Without it, members are
+	// fetched over REST when they're first needed and cached from then on.
+	session.Identify.Intents = discordgo.IntentsAllWithoutPrivileged
+	if os.Getenv("DISCORD_MEMBERS_INTENT") == "true" {
+		session.Identify.Intents |= discordgo.IntentsGuildMembers
+	}
+	session.State.TrackMembers = true
+	session.State.TrackRoles = true
+
 	err = session.Open()
 	if err != nil {
 		panic(err)
 	}
 
-	var bot botService
+	denial, err := loadDenialConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	dbCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	db, err := openDatabase(dbCtx)
+	cancel()
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db.Close(ctx)
+	}()
+
+	migrateCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	err = migrateOnStart(migrateCtx, db)
+	cancel()
+	if err != nil {
+		panic(err)
+	}
+
+	go monitorDatabase(db, time.Minute)
+
+	reminders := newReminderStore(db)
+	settings := newUserSettingsStore(db)
+	scheduler := newReminderScheduler(reminders, reminderInterval(), func(ctx context.Context, r Reminder) error {
+		return deliverReminder(ctx, session, settings, r)
+	})
+
+	bot := botService{
+		denial:    denial,
+		reminders: reminders,
+		scheduler: scheduler,
+		settings:  settings,
+	}
 	if key, ok := os.LookupEnv("OPTIMIZELY_KEY"); ok && key != "" {
 		optimizelyFactory := &client.OptimizelyFactory{
 			SDKKey: os.Getenv("OPTIMIZELY_KEY"),
@@ -45,29 +101,60 @@ func main() {
 		}
 		defer optlyClient.Close()
 
-		bot = botService{
-			flags: optlyClient,
-		}
-	} else {
-		bot = botService{}
+		bot.flags = optlyClient
 	}
 
 	// Wait for the user to cancel the process
-	defer func() {
-		sc := make(chan os.Signal, 1)
-		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
-		<-sc
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+	defer stop()
+
+	// only one replica sends and purges reminders at a time
+	leases := newLeaseStore(db)
+	leaseTTL := time.Duration(envOrDefaultInt("REMINDER_LEASE_SECONDS", 30)) * time.Second
+	leading := make(chan struct{})
+	go func() {
+		defer close(leading)
+		runAsLeader(ctx, leases, "reminder-scheduler", scheduler.instance, leaseTTL, func(ctx context.Context) {
+			go purgeReminders(ctx, reminders, reminderRetention(), time.Hour)
+			scheduler.Run(ctx)
+		})
 	}()
 
-	go sendReminders(session)
+	// the lease is released on the way out, before the database closes, so another
+	// replica can take over without waiting for it to expire
+	defer func() {
+		<-ctx.Done()
+		<-leading
+		session.Close()
+	}()
 
-	session.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsGuildMessages)
+	if calendarFeedURL() != "" {
+		go func() {
+			if err := serveCalendarFeed(context.Background(), &calendarFeed{reminders: reminders, settings: settings}); err != nil {
+				fmt.Fprintf(os.Stderr, "calendar feed: %v\n", err)
+			}
+		}()
+	}
 
 	session.AddHandler(bot.MessageRespond)
 	session.AddHandler(bot.MessageReact)
+	session.AddHandler(bot.InteractionRespond)
 	session.AddHandler(bot.JoinThread)
 }
 
+// runSubcommand runs one of the maintenance commands instead of starting the bot
+func runSubcommand(args []string) int {
+	switch args[0] {
+	case "flags":
+		return runFlagsCommand(args[1:], os.Stdout)
+	case "migrate":
+		return runMigrateCommand(args[1:], os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return 2
+	}
+}
+
 func getFeatureFlagState(ctx context.Context, optClient FeatureFlags, id string, roles []string, flag string) bool {
 
 	ctx, span := beeline.StartSpan(ctx, "get_feature_flag_main")
@@ -76,6 +163,17 @@ func getFeatureFlagState(ctx context.Context, optClient FeatureFlags, id string,
 	beeline.AddField(ctx, "feature_flag_name", flag)
 	beeline.AddField(ctx, "feature_flag_role", roles)
 
+	// direct messages and members without roles are targeted on the user alone
+	if len(roles) == 0 {
+		enabled, err := optClient.IsFeatureEnabled(flag, entities.UserContext{ID: id, Attributes: map[string]interface{}{}})
+		if err != nil {
+			beeline.AddField(ctx, "feature_flag.Error", err)
+			return false
+		}
+		beeline.AddField(ctx, "feature_flag_enabled", enabled)
+		return enabled
+	}
+
 	enabled := false
 
 	for _, role := range roles {
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/honeycombio/beeline-go"
+)
+
+// guildMember looks the member up in the gateway state cache, falling back to the REST
+// API on a miss and caching the result for next time.
+func guildMember(ctx context.Context, s *discordgo.Session, guildID string, userID string) (*discordgo.Member, error) {
+	ctx, span := beeline.StartSpan(ctx, "guildMember")
+	defer span.Send()
+
+	span.AddField("guildMember.guildID", guildID)
+	span.AddField("guildMember.userID", userID)
+
+	if member, err := s.State.Member(guildID, userID); err == nil {
+		span.AddField("guildMember.cache", "hit")
+		return member, nil
+	}
+
+	span.AddField("guildMember.cache", "miss")
+
+	member, err := s.GuildMember(guildID, userID)
+	if err != nil {
+		span.AddField("guildMember.error", err)
+		return nil, err
+	}
+
+	if err := s.State.MemberAdd(member); err != nil {
+		span.AddField("guildMember.cache.error", err)
+	}
+
+	return member, nil
+}
+
+// guildRoles returns the guild's roles from the gateway state cache, falling back to
+// the REST API when the guild hasn't been cached.
+func guildRoles(ctx context.Context, s *discordgo.Session, guildID string) ([]*discordgo.Role, error) {
+	ctx, span := beeline.StartSpan(ctx, "guildRoles")
+	defer span.Send()
+
+	span.AddField("guildRoles.guildID", guildID)
+
+	if roles := stateRoles(s.State, guildID); len(roles) > 0 {
+		span.AddField("guildRoles.cache", "hit")
+		return roles, nil
+	}
+
+	span.AddField("guildRoles.cache", "miss")
+
+	roles, err := s.GuildRoles(guildID)
+	if err != nil {
+		span.AddField("guildRoles.error", err)
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if err := s.State.RoleAdd(guildID, role); err != nil {
+			span.AddField("guildRoles.cache.error", err)
+			break
+		}
+	}
+
+	return roles, nil
+}
+
+// guildChannel looks up a channel, using the gateway state where possible
+func guildChannel(ctx context.Context, s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	ctx, span := beeline.StartSpan(ctx, "guildChannel")
+	defer span.Send()
+
+	span.AddField("guildChannel.channelID", channelID)
+
+	if channel, err := s.State.Channel(channelID); err == nil {
+		span.AddField("guildChannel.cache", "hit")
+		return channel, nil
+	}
+
+	span.AddField("guildChannel.cache", "miss")
+
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		span.AddField("guildChannel.error", err)
+		return nil, err
+	}
+
+	if err := s.State.ChannelAdd(channel); err != nil {
+		span.AddField("guildChannel.cache.error", err)
+	}
+
+	return channel, nil
+}
+
+// findMembers returns the guild's members whose nickname or username is name, ignoring
+// case. It searches the gateway state first, then the REST API if nobody matched there.
+func findMembers(ctx context.Context, s *discordgo.Session, guildID string, name string) ([]*discordgo.Member, error) {
+	ctx, span := beeline.StartSpan(ctx, "findMembers")
+	defer span.Send()
+
+	span.AddField("findMembers.guildID", guildID)
+
+	matches := func(members []*discordgo.Member) []*discordgo.Member {
+		var found []*discordgo.Member
+		for _, member := range members {
+			if strings.EqualFold(memberDisplayName(member), name) || strings.EqualFold(member.User.Username, name) {
+				found = append(found, member)
+			}
+		}
+		return found
+	}
+
+	if found := matches(stateMembers(s.State, guildID)); len(found) > 0 {
+		span.AddField("findMembers.cache", "hit")
+		span.AddField("findMembers.found", len(found))
+		return found, nil
+	}
+
+	span.AddField("findMembers.cache", "miss")
+
+	members, err := s.GuildMembersSearch(guildID, name, 100)
+	if err != nil {
+		span.AddField("findMembers.error", err)
+		return nil, err
+	}
+
+	found := matches(members)
+	span.AddField("findMembers.found", len(found))
+	return found, nil
+}
+
+// stateRoles copies the guild's cached roles while holding the state lock, as the gateway
+// updates them concurrently
+func stateRoles(state *discordgo.State, guildID string) []*discordgo.Role {
+	guild, err := state.Guild(guildID)
+	if err != nil {
+		return nil
+	}
+
+	state.RLock()
+	defer state.RUnlock()
+	return append([]*discordgo.Role(nil), guild.Roles...)
+}
+
+// stateMembers copies the guild's cached members while holding the state lock
+func stateMembers(state *discordgo.State, guildID string) []*discordgo.Member {
+	guild, err := state.Guild(guildID)
+	if err != nil {
+		return nil
+	}
+
+	state.RLock()
+	defer state.RUnlock()
+	return append([]*discordgo.Member(nil), guild.Members...)
+}
+
+// memberDisplayName returns the member's nickname, or their username if they don't have one
+func memberDisplayName(member *discordgo.Member) string {
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.Username
+}
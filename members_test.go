@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestGetMemberRolesFromState(t *testing.T) {
+
+	state := discordgo.NewState()
+	err := state.GuildAdd(&discordgo.Guild{
+		ID: "guild",
+		Roles: []*discordgo.Role{
+			{ID: "1", Name: "admin"},
+			{ID: "2", Name: "member"},
+			{ID: "3", Name: "bots"},
+		},
+		Members: []*discordgo.Member{
+			{GuildID: "guild", User: &discordgo.User{ID: "1001", Username: "chris"}, Roles: []string{"1", "2"}},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: "2001", GuildID: "guild", Name: "general"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GuildAdd: %v", err)
+	}
+
+	// No token is set, so any fallback to the REST API would fail
+	s := &discordgo.Session{State: state}
+
+	message := &discordgo.Message{GuildID: "guild", Author: &discordgo.User{ID: "1001", Username: "chris"}}
+
+	roles, err := getMemberRoles(context.Background(), s, message)
+	if err != nil {
+		t.Fatalf("getMemberRoles: FAILED, unexpected error %v", err)
+	}
+
+	if expected := []string{"admin", "member"}; !reflect.DeepEqual(roles, expected) {
+		t.Errorf("getMemberRoles: FAILED, expected %v but got %v", expected, roles)
+	}
+
+	replaced := replaceMentions(context.Background(), s, "ping <@!1001> and <@&1> about it in <#2001>", "guild")
+	if expected := "ping @chris and @admin about it in #general"; replaced != expected {
+		t.Errorf("replaceMentions: FAILED, expected %v but got %v", expected, replaced)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+	"github.com/honeycombio/beeline-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// migration is a versioned change to the database. Migrations run in version order and
+// each runs once, but they're written so running one twice, e.g. when two replicas start
+// together, does no harm.
+type migration struct {
+	version int
+	name    string
+	run     func(ctx context.Context, db gosmosdb.Database, now time.Time) error
+}
+
+// migrations are never edited or reordered once released, only added to
+var migrations = []migration{
+	{1, "backfill reminder IDs", backfillReminderIDs},
+	{2, "backfill reminder status", backfillReminderStatus},
+	{3, "create reminder and settings indexes", createIndexes},
+	{4, "backfill reminder schema version", backfillSchemaVersion},
+}
+
+// appliedMigration records a migration that has been run, in MIGRATION_COLLECTION
+// (default migrations)
+type appliedMigration struct {
+	Version int       `json:"version" bson:"_id"`
+	Name    string    `json:"name" bson:"name"`
+	Applied time.Time `json:"applied" bson:"applied"`
+}
+
+func migrationRepository(db gosmosdb.Database) gosmosdb.Repository[appliedMigration] {
+	return gosmosdb.NewRepository[appliedMigration](db.Collection(envOrDefault("MIGRATION_COLLECTION", "migrations")))
+}
+
+// runMigrations applies every migration that hasn't been run yet, returning those it ran
+func runMigrations(ctx context.Context, db gosmosdb.Database, migrations []migration) ([]migration, error) {
+	ctx, span := beeline.StartSpan(ctx, "runMigrations")
+	defer span.Send()
+
+	repo := migrationRepository(db)
+	applied, err := appliedVersions(ctx, repo)
+	if err != nil {
+		span.AddField("runMigrations.error", err)
+		return nil, err
+	}
+
+	var ran []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		span.AddField("runMigrations.version", m.version)
+		now := time.Now()
+		if err := m.run(ctx, db, now); err != nil {
+			span.AddField("runMigrations.error", err)
+			return ran, fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+
+		err := repo.Insert(ctx, appliedMigration{Version: m.version, Name: m.name, Applied: now})
+		if err != nil && !errors.Is(err, gosmosdb.ErrDuplicate) {
+			span.AddField("runMigrations.error", err)
+			return ran, err
+		}
+		ran = append(ran, m)
+	}
+
+	span.AddField("runMigrations.ran", len(ran))
+	return ran, nil
+}
+
+func appliedVersions(ctx context.Context, repo gosmosdb.Repository[appliedMigration]) (map[int]appliedMigration, error) {
+	records, err := repo.Find(ctx, gosmosdb.Where())
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]appliedMigration)
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// backfillReminderIDs gives reminders created before they had IDs one, so they can be
+// cancelled and edited
+func backfillReminderIDs(ctx context.Context, db gosmosdb.Database, now time.Time) error {
+	repo := gosmosdb.NewRepository[bson.M](db.Collection(reminderCollection()))
+
+	docs, err := repo.Find(ctx, gosmosdb.Where(gosmosdb.Eq("id", nil), gosmosdb.Eq("botsource", reminderBotSource)))
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		id, err := newReminderID()
+		if err != nil {
+			return err
+		}
+
+		// Mongo gives every document an _id, but the memory store doesn't
+		filter := gosmosdb.Where(gosmosdb.Eq("_id", doc["_id"]), gosmosdb.Eq("id", nil))
+		if doc["_id"] == nil {
+			filter = gosmosdb.Where(
+				gosmosdb.Eq("sourceMessage", doc["sourceMessage"]),
+				gosmosdb.Eq("creator", doc["creator"]),
+				gosmosdb.Eq("due", doc["due"]),
+				gosmosdb.Eq("id", nil),
+			)
+		}
+
+		if _, err := repo.Update(ctx, filter, gosmosdb.Update{"id": id}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backfillReminderStatus sets the status of reminders from before delivery was tracked.
+// They were sent once due, so anything already due is delivered rather than being sent
+// again as overdue.
+func backfillReminderStatus(ctx context.Context, db gosmosdb.Database, now time.Time) error {
+	repo := gosmosdb.NewRepository[bson.M](db.Collection(reminderCollection()))
+	noStatus := func(conditions ...gosmosdb.Condition) gosmosdb.Filter {
+		return gosmosdb.Where(append(conditions, gosmosdb.In("status", "", nil), gosmosdb.Eq("botsource", reminderBotSource))...)
+	}
+
+	if _, err := repo.Update(ctx, noStatus(gosmosdb.Lt("due", now)), gosmosdb.Update{"status": reminderDelivered}); err != nil {
+		return err
+	}
+	_, err := repo.Update(ctx, noStatus(), gosmosdb.Update{"status": reminderPending, "attempts": 0})
+	return err
+}
+
+// createIndexes covers the queries the bot makes. None are unique, as Cosmos DB can only
+// add a unique index to an empty collection.
+func createIndexes(ctx context.Context, db gosmosdb.Database, now time.Time) error {
+	indexes := map[string][]gosmosdb.Index{
+		reminderCollection(): {
+			{Name: "id", Fields: []string{"id"}},
+			{Name: "status_due", Fields: []string{"botsource", "status", "due"}},
+			{Name: "server_due", Fields: []string{"botsource", "server", "due"}},
+			{Name: "creator_due", Fields: []string{"botsource", "creator", "due"}},
+		},
+		userSettingsCollection(): {
+			{Name: "userId", Fields: []string{"userId"}},
+			{Name: "calendarToken", Fields: []string{"calendarToken"}},
+		},
+	}
+
+	for collection, list := range indexes {
+		for _, index := range list {
+			if err := db.Collection(collection).EnsureIndex(ctx, index); err != nil {
+				return fmt.Errorf("index %s on %s: %w", index.Name, collection, err)
+			}
+		}
+	}
+	return nil
+}
+
+// backfillSchemaVersion marks reminders from before documents were versioned as the first
+// version, which the earlier migrations have brought them up to
+func backfillSchemaVersion(ctx context.Context, db gosmosdb.Database, now time.Time) error {
+	repo := gosmosdb.NewRepository[bson.M](db.Collection(reminderCollection()))
+	_, err := repo.Update(ctx, gosmosdb.Where(gosmosdb.In("schemaVersion", 0, nil), gosmosdb.Eq("botsource", reminderBotSource)), gosmosdb.Update{"schemaVersion": 1})
+	return err
+}
+
+// runMigrateCommand applies pending migrations, or lists them with migrate status
+func runMigrateCommand(args []string, out io.Writer) int {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "status") {
+		fmt.Fprintln(out, "usage: go-discord-bot migrate [status]")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	db, err := openDatabase(ctx)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	defer db.Close(ctx)
+
+	if len(args) == 1 {
+		applied, err := appliedVersions(ctx, migrationRepository(db))
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		for _, m := range migrations {
+			if a, ok := applied[m.version]; ok {
+				fmt.Fprintf(out, "%d %s: applied %s\n", m.version, m.name, a.Applied.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(out, "%d %s: pending\n", m.version, m.name)
+			}
+		}
+		return 0
+	}
+
+	ran, err := runMigrations(ctx, db, migrations)
+	for _, m := range ran {
+		fmt.Fprintf(out, "applied %d %s\n", m.version, m.name)
+	}
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	if len(ran) == 0 {
+		fmt.Fprintln(out, "migrations up to date")
+	}
+	return 0
+}
+
+// migrateOnStart runs pending migrations before the bot starts, unless
+// MIGRATE_ON_START is false
+func migrateOnStart(ctx context.Context, db gosmosdb.Database) error {
+	if os.Getenv("MIGRATE_ON_START") == "false" {
+		return nil
+	}
+	_, err := runMigrations(ctx, db, migrations)
+	return err
+}
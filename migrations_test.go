@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRunMigrations(t *testing.T) {
+
+	ctx := context.Background()
+	db := gosmosdb.NewMemoryDatabase()
+	now := time.Now()
+
+	// reminders written before they had IDs, statuses or a schema version
+	raw := gosmosdb.NewRepository[bson.M](db.Collection(reminderCollection()))
+	old := []bson.M{
+		{"due": now.Add(-time.Hour), "message": "sent already", "creator": "4001", "sourceMessage": "3001", "botsource": reminderBotSource},
+		{"due": now.Add(time.Hour), "message": "still to come", "creator": "4001", "sourceMessage": "3002", "botsource": reminderBotSource},
+		{"due": now.Add(time.Hour), "message": "another bot's", "botsource": "OtherBot"},
+	}
+	for _, doc := range old {
+		if err := raw.Insert(ctx, doc); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+
+	// they can't be claimed or marked delivered, so aren't sent until they're migrated
+	store := newReminderStore(db)
+	if due, _ := store.Due(ctx, time.Time{}, now.Add(2*time.Hour)); len(due) != 0 {
+		t.Errorf("Due before migrating: FAILED, expected no reminders but got %v", due)
+	}
+
+	ran, err := runMigrations(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("runMigrations: FAILED, unexpected error %v", err)
+	}
+	if len(ran) != len(migrations) {
+		t.Errorf("runMigrations: FAILED, expected %d migrations to run but ran %d", len(migrations), len(ran))
+	}
+
+	due, _ := store.Due(ctx, time.Time{}, now.Add(2*time.Hour))
+	if len(due) != 1 || due[0].Message != "still to come" {
+		t.Fatalf("runMigrations: FAILED, expected only the future reminder to be pending but got %v", due)
+	}
+	if due[0].ID == "" || due[0].SchemaVersion != 1 {
+		t.Errorf("runMigrations: FAILED, expected an ID and schema version but got %+v", due[0])
+	}
+	if r, err := store.Get(ctx, due[0].ID); err != nil || r.Message != "still to come" {
+		t.Errorf("runMigrations: FAILED, expected to find the reminder by its new ID but got %v %v", r, err)
+	}
+
+	if n, _ := raw.Count(ctx, gosmosdb.Where(gosmosdb.Eq("botsource", "OtherBot"), gosmosdb.Eq("status", nil))); n != 1 {
+		t.Errorf("runMigrations: FAILED, expected another bot's documents to be left alone")
+	}
+
+	ran, err = runMigrations(ctx, db, migrations)
+	if err != nil || len(ran) != 0 {
+		t.Errorf("runMigrations again: FAILED, expected nothing to run but ran %d (%v)", len(ran), err)
+	}
+
+	// a new migration runs on its own
+	extra := append(migrations, migration{len(migrations) + 1, "test", func(ctx context.Context, db gosmosdb.Database, now time.Time) error { return nil }})
+	if ran, _ := runMigrations(ctx, db, extra); len(ran) != 1 || ran[0].name != "test" {
+		t.Errorf("runMigrations with a new migration: FAILED, expected just it to run but ran %v", ran)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence repeats a reminder. It either repeats every Every units, or on the listed
+// weekdays, or on a day of the month, at the time of day At in Timezone. A recurring
+// reminder keeps a single ID and its Due is moved on to the next occurrence after each
+// delivery until Until.
+type Recurrence struct {
+	Every    int            `json:"every" bson:"every"`
+	Unit     string         `json:"unit" bson:"unit"`
+	Weekdays []time.Weekday `json:"weekdays,omitempty" bson:"weekdays,omitempty"`
+	MonthDay int            `json:"monthDay,omitempty" bson:"monthDay,omitempty"`
+	At       string         `json:"at,omitempty" bson:"at,omitempty"`
+	Timezone string         `json:"timezone" bson:"timezone"`
+	Until    time.Time      `json:"until,omitempty" bson:"until,omitempty"`
+}
+
+// Recurrence units
+const (
+	recurMinute = "minute"
+	recurHour   = "hour"
+	recurDay    = "day"
+	recurWeek   = "week"
+	recurMonth  = "month"
+)
+
+func (rec Recurrence) location() *time.Location {
+	if loc, err := time.LoadLocation(rec.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// clock returns t moved to the recurrence's time of day, or t itself if it has none
+func (rec Recurrence) clock(t time.Time) time.Time {
+	at, err := time.Parse("15:04", rec.At)
+	if err != nil {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), at.Hour(), at.Minute(), 0, 0, t.Location())
+}
+
+// next returns the first occurrence after prev, or false once the series has ended
+func (rec Recurrence) next(prev time.Time) (time.Time, bool) {
+	prev = prev.In(rec.location())
+	every := rec.Every
+	if every < 1 {
+		every = 1
+	}
+
+	var next time.Time
+	switch {
+	case len(rec.Weekdays) > 0:
+		day := rec.clock(prev)
+		for i := 0; i <= 7; i++ {
+			c := day.AddDate(0, 0, i)
+			if c.After(prev) && containsWeekday(rec.Weekdays, c.Weekday()) {
+				next = c
+				break
+			}
+		}
+	case rec.MonthDay > 0:
+		for m := 0; ; m += every {
+			first := time.Date(prev.Year(), prev.Month()+time.Month(m), 1, 0, 0, 0, 0, prev.Location())
+			day := rec.MonthDay
+			if last := first.AddDate(0, 1, -1).Day(); day > last {
+				day = last
+			}
+			c := rec.clock(time.Date(first.Year(), first.Month(), day, prev.Hour(), prev.Minute(), prev.Second(), 0, prev.Location()))
+			if c.After(prev) {
+				next = c
+				break
+			}
+		}
+	case rec.Unit == recurMinute:
+		next = prev.Add(time.Duration(every) * time.Minute)
+	case rec.Unit == recurHour:
+		next = prev.Add(time.Duration(every) * time.Hour)
+	default:
+		next = rec.clock(prev)
+		if !next.After(prev) {
+			switch rec.Unit {
+			case recurWeek:
+				next = next.AddDate(0, 0, 7*every)
+			case recurMonth:
+				next = next.AddDate(0, every, 0)
+			default:
+				next = next.AddDate(0, 0, every)
+			}
+		}
+	}
+
+	if next.IsZero() || (!rec.Until.IsZero() && next.After(rec.Until)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// nextAfter returns the first occurrence following prev that is after now, skipping any
+// missed while the bot was down
+func (rec Recurrence) nextAfter(prev time.Time, now time.Time) (time.Time, bool) {
+	next, ok := rec.next(prev)
+	for ok && !next.After(now) {
+		next, ok = rec.next(next)
+	}
+	return next, ok
+}
+
+// minGap is the shortest time between two occurrences
+func (rec Recurrence) minGap() time.Duration {
+	every := time.Duration(rec.Every)
+	if every < 1 {
+		every = 1
+	}
+
+	switch {
+	case len(rec.Weekdays) > 0 || rec.MonthDay > 0:
+		return 24 * time.Hour
+	case rec.Unit == recurMinute:
+		return every * time.Minute
+	case rec.Unit == recurHour:
+		return every * time.Hour
+	default:
+		// days vary with daylight saving, so count the shortest
+		return every * 23 * time.Hour
+	}
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (rec Recurrence) String() string {
+	var b strings.Builder
+	b.WriteString("every ")
+
+	switch {
+	case len(rec.Weekdays) > 0:
+		if len(rec.Weekdays) == 5 && !containsWeekday(rec.Weekdays, time.Saturday) && !containsWeekday(rec.Weekdays, time.Sunday) {
+			b.WriteString("weekday")
+		} else {
+			var names []string
+			for _, d := range rec.Weekdays {
+				names = append(names, d.String())
+			}
+			b.WriteString(strings.Join(names, ", "))
+		}
+	case rec.MonthDay > 0:
+		if rec.Every > 1 {
+			fmt.Fprintf(&b, "%d months", rec.Every)
+		} else {
+			b.WriteString("month")
+		}
+		fmt.Fprintf(&b, " on the %s", ordinal(rec.MonthDay))
+	case rec.Every > 1:
+		fmt.Fprintf(&b, "%d %ss", rec.Every, rec.Unit)
+	default:
+		b.WriteString(rec.Unit)
+	}
+
+	if rec.At != "" {
+		fmt.Fprintf(&b, " at %s", rec.At)
+	}
+	if rec.Timezone != "" && rec.Timezone != "UTC" {
+		fmt.Fprintf(&b, " %s", rec.Timezone)
+	}
+	if !rec.Until.IsZero() {
+		fmt.Fprintf(&b, " until %s", rec.Until.In(rec.location()).Format("2006-01-02"))
+	}
+
+	return b.String()
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+var (
+	recurEveryPattern = regexp.MustCompile(`(?i)\bevery\s+(?:(\d+)\s+)?(minute|hour|day|week|month|weekday|monday|tuesday|wednesday|thursday|friday|saturday|sunday)s?\b`)
+	recurOnPattern    = regexp.MustCompile(`(?i)\bon\s+the\s+(\d{1,2})(?:st|nd|rd|th)?\b`)
+	recurUntilPattern = regexp.MustCompile(`(?i)\buntil\s+(\d{4}-\d{2}-\d{2})\b`)
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// parseRecurrence looks for a rule like "every weekday at 09:30", "every 2 weeks" or
+// "every month on the 1st until 2023-01-01" in text. It returns the rule, its first
+// occurrence after from and the text with the rule removed, or false if there's no rule.
+func parseRecurrence(text string, from time.Time, loc *time.Location) (Recurrence, time.Time, string, bool, error) {
+	every := recurEveryPattern.FindStringSubmatch(text)
+	if every == nil {
+		return Recurrence{}, time.Time{}, text, false, nil
+	}
+	text = strings.Replace(text, every[0], "", 1)
+
+	rec := Recurrence{Every: 1, Timezone: loc.String()}
+	if every[1] != "" {
+		rec.Every, _ = strconv.Atoi(every[1])
+		if rec.Every < 1 {
+			return Recurrence{}, time.Time{}, text, false, fmt.Errorf("Reminders can't repeat every 0 %ss", every[2])
+		}
+	}
+
+	unit := strings.ToLower(every[2])
+	day, isDay := weekdayNames[unit]
+	switch {
+	case unit == "weekday":
+		rec.Unit = recurDay
+		rec.Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	case isDay:
+		rec.Unit = recurDay
+		rec.Weekdays = []time.Weekday{day}
+	default:
+		rec.Unit = unit
+	}
+
+	if rec.Every > 1 && len(rec.Weekdays) > 0 {
+		return Recurrence{}, time.Time{}, text, false, fmt.Errorf("Reminders on a weekday can only repeat every week")
+	}
+
+	if on := recurOnPattern.FindStringSubmatch(text); on != nil && rec.Unit == recurMonth {
+		rec.MonthDay, _ = strconv.Atoi(on[1])
+		if rec.MonthDay < 1 || rec.MonthDay > 31 {
+			return Recurrence{}, time.Time{}, text, false, fmt.Errorf("%s isn't a day of the month", on[1])
+		}
+		text = strings.Replace(text, on[0], "", 1)
+	}
+
+	if at := atClockPattern.FindStringSubmatch(text); at != nil {
+		hour, minute, err := parseClock(at[0][2:])
+		if err != nil {
+			return Recurrence{}, time.Time{}, text, false, err
+		}
+		rec.At = fmt.Sprintf("%02d:%02d", hour, minute)
+		text = strings.Replace(text, at[0], "", 1)
+	}
+
+	if until := recurUntilPattern.FindStringSubmatch(text); until != nil {
+		end, err := time.ParseInLocation("2006-01-02", until[1], loc)
+		if err != nil {
+			return Recurrence{}, time.Time{}, text, false, fmt.Errorf("%s isn't a date", until[1])
+		}
+		// include the whole of the last day
+		rec.Until = end.AddDate(0, 0, 1).Add(-time.Second)
+		text = strings.Replace(text, until[0], "", 1)
+	}
+
+	if (rec.Unit == recurMinute || rec.Unit == recurHour) && (rec.At != "" || rec.MonthDay > 0) {
+		return Recurrence{}, time.Time{}, text, false, fmt.Errorf("Reminders repeating every %s can't have a time of day", rec.Unit)
+	}
+
+	first, ok := rec.next(from)
+	if !ok {
+		return Recurrence{}, time.Time{}, text, false, fmt.Errorf("That reminder would never fire")
+	}
+
+	return rec, first, text, true, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecurrence(t *testing.T) {
+
+	// Friday 3rd June 2022, 10:00 UTC
+	from := time.Date(2022, 6, 3, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		text    string
+		message string
+		rule    string
+		first   time.Time
+		second  time.Time
+	}{
+		{"every weekday at 09:30 standup", "standup", "every weekday at 09:30", time.Date(2022, 6, 6, 9, 30, 0, 0, time.UTC), time.Date(2022, 6, 7, 9, 30, 0, 0, time.UTC)},
+		{"every 2 weeks pay rent", "pay rent", "every 2 weeks", from.AddDate(0, 0, 14), from.AddDate(0, 0, 28)},
+		{"every month on the 1st check alarms", "check alarms", "every month on the 1st", time.Date(2022, 7, 1, 10, 0, 0, 0, time.UTC), time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC)},
+		{"every month on the 31st at 17:00 invoices", "invoices", "every month on the 31st at 17:00", time.Date(2022, 6, 30, 17, 0, 0, 0, time.UTC), time.Date(2022, 7, 31, 17, 0, 0, 0, time.UTC)},
+		{"stretch every day at 11:00", "stretch", "every day at 11:00", time.Date(2022, 6, 3, 11, 0, 0, 0, time.UTC), time.Date(2022, 6, 4, 11, 0, 0, 0, time.UTC)},
+		{"every friday at 16:00 until 2022-06-10 demo", "demo", "every Friday at 16:00 until 2022-06-10", time.Date(2022, 6, 3, 16, 0, 0, 0, time.UTC), time.Date(2022, 6, 10, 16, 0, 0, 0, time.UTC)},
+		{"every 3 hours drink water", "drink water", "every 3 hours", from.Add(3 * time.Hour), from.Add(6 * time.Hour)},
+	}
+
+	for _, tc := range tests {
+		rec, first, text, ok, err := parseRecurrence(tc.text, from, time.UTC)
+		if err != nil || !ok {
+			t.Errorf("parseRecurrence %q: FAILED, unexpected error %v", tc.text, err)
+			continue
+		}
+		if got := rec.String(); got != tc.rule {
+			t.Errorf("parseRecurrence %q: FAILED, expected rule %v but got %v", tc.text, tc.rule, got)
+		}
+		if !first.Equal(tc.first) {
+			t.Errorf("parseRecurrence %q: FAILED, expected first at %v but got %v", tc.text, tc.first, first)
+		}
+		if second, _ := rec.next(first); !second.Equal(tc.second) {
+			t.Errorf("parseRecurrence %q: FAILED, expected second at %v but got %v", tc.text, tc.second, second)
+		}
+		if got := strings.Join(strings.Fields(text), " "); got != tc.message {
+			t.Errorf("parseRecurrence %q: FAILED, expected message %q but got %q", tc.text, tc.message, got)
+		}
+	}
+
+	if _, _, _, ok, _ := parseRecurrence("post memes 1h", from, time.UTC); ok {
+		t.Errorf("parseRecurrence without every: FAILED, expected no rule")
+	}
+	if _, _, _, _, err := parseRecurrence("every 2 mondays at 25:00 nope", from, time.UTC); err == nil {
+		t.Errorf("parseRecurrence with an invalid rule: FAILED, expected an error")
+	}
+}
+
+func TestRecurrenceEndsAndSkipsMissed(t *testing.T) {
+
+	until := time.Date(2022, 6, 10, 23, 59, 59, 0, time.UTC)
+	rec := Recurrence{Every: 1, Unit: recurDay, Weekdays: []time.Weekday{time.Friday}, At: "16:00", Timezone: "UTC", Until: until}
+
+	if _, ok := rec.next(time.Date(2022, 6, 10, 16, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("next after the last occurrence: FAILED, expected the series to end")
+	}
+
+	daily := Recurrence{Every: 1, Unit: recurDay, At: "09:00", Timezone: "Europe/London"}
+	now := time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC)
+	next, ok := daily.nextAfter(time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC), now)
+	if want := time.Date(2022, 6, 9, 8, 0, 0, 0, time.UTC); !ok || !next.Equal(want) {
+		t.Errorf("nextAfter: FAILED, expected %v but got %v", want, next)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// reminderLimits stop one user or server filling the reminder store and the scheduler.
+// A zero limit isn't enforced.
+type reminderLimits struct {
+	// maxPerUser is how many pending reminders one user can have across every server
+	maxPerUser int
+	// maxPerGuild is how many pending reminders a server can have between all its users
+	maxPerGuild int
+	// maxHorizon is how far ahead a reminder can first be due
+	maxHorizon time.Duration
+	// minRepeat is the shortest gap allowed between occurrences of a recurring reminder
+	minRepeat time.Duration
+	// maxLength is the longest reminder message allowed, in characters
+	maxLength int
+}
+
+// reminderLimitsFromEnv reads the limits from REMINDER_MAX_PER_USER (default 25),
+// REMINDER_MAX_PER_GUILD (default 500), REMINDER_MAX_DAYS (default 366),
+// REMINDER_MIN_REPEAT_MINUTES (default 15) and REMINDER_MAX_LENGTH (default 1000)
+func reminderLimitsFromEnv() reminderLimits {
+	return reminderLimits{
+		maxPerUser:  envOrDefaultInt("REMINDER_MAX_PER_USER", 25),
+		maxPerGuild: envOrDefaultInt("REMINDER_MAX_PER_GUILD", 500),
+		maxHorizon:  time.Duration(envOrDefaultInt("REMINDER_MAX_DAYS", 366)) * 24 * time.Hour,
+		minRepeat:   time.Duration(envOrDefaultInt("REMINDER_MIN_REPEAT_MINUTES", 15)) * time.Minute,
+		maxLength:   envOrDefaultInt("REMINDER_MAX_LENGTH", 1000),
+	}
+}
+
+// check returns an error explaining which limit a new reminder breaks, if any
+func (l reminderLimits) check(ctx context.Context, store ReminderStore, r Reminder, now time.Time) error {
+	if err := l.checkReminder(r, now); err != nil {
+		return err
+	}
+	return l.checkCount(ctx, store, r)
+}
+
+// checkReminder checks the limits on the reminder itself, which also apply when it's
+// edited
+func (l reminderLimits) checkReminder(r Reminder, now time.Time) error {
+	if l.maxLength > 0 && len([]rune(r.Message)) > l.maxLength {
+		return fmt.Errorf("Reminder messages can be at most %d characters", l.maxLength)
+	}
+
+	if l.maxHorizon > 0 && r.Due.After(now.Add(l.maxHorizon)) {
+		return fmt.Errorf("Reminders can be set at most %d days ahead", int(l.maxHorizon.Hours()/24))
+	}
+
+	if l.minRepeat > 0 && r.Recurrence != nil && r.Recurrence.minGap() < l.minRepeat {
+		return fmt.Errorf("Reminders can repeat at most every %s", l.minRepeat)
+	}
+
+	return nil
+}
+
+// checkCount checks there's room for another pending reminder from the creator and on
+// the server
+func (l reminderLimits) checkCount(ctx context.Context, store ReminderStore, r Reminder) error {
+	if l.maxPerUser > 0 {
+		n, err := store.CountPending(ctx, "", r.Creator)
+		if err != nil {
+			return err
+		}
+		if n >= l.maxPerUser {
+			return fmt.Errorf("You already have %d reminders, cancel some with !remindme cancel <id> before adding more", n)
+		}
+	}
+
+	if l.maxPerGuild > 0 && r.Server != "" {
+		n, err := store.CountPending(ctx, r.Server, "")
+		if err != nil {
+			return err
+		}
+		if n >= l.maxPerGuild {
+			return fmt.Errorf("This server already has %d reminders, which is as many as it can have", n)
+		}
+	}
+
+	return nil
+}
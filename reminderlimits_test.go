@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReminderLimits(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	sent := time.Now().Truncate(time.Millisecond)
+	opts := reminderOptions{limits: reminderLimits{
+		maxPerUser:  2,
+		maxPerGuild: 3,
+		maxHorizon:  7 * 24 * time.Hour,
+		minRepeat:   time.Hour,
+		maxLength:   20,
+	}}
+
+	tests := []struct {
+		content string
+		allowed bool
+	}{
+		{"stretch 8d", false},
+		{"stretch every 30 minutes", false},
+		{"this reminder message is far too long 1h", false},
+		{"stretch 6d", true},
+		{"stretch every 2 hours", true},
+		{"stretch 1h", false},
+	}
+
+	for _, tc := range tests {
+		_, err := createReminder(ctx, store, nil, testReminderMessage(tc.content, sent), opts)
+		if tc.allowed && err != nil {
+			t.Errorf("createReminder %q: FAILED, unexpected error %v", tc.content, err)
+		} else if !tc.allowed && err == nil {
+			t.Errorf("createReminder %q: FAILED, expected it to be over a limit", tc.content)
+		}
+	}
+
+	// another user on the same server is stopped by the server limit
+	message := testReminderMessage("stretch 1h", sent)
+	message.Author.ID = "4002"
+	if _, err := createReminder(ctx, store, nil, message, opts); err != nil {
+		t.Fatalf("createReminder for another user: FAILED, unexpected error %v", err)
+	}
+	_, err := createReminder(ctx, store, nil, message, opts)
+	if err == nil || !strings.Contains(err.Error(), "This server") {
+		t.Errorf("createReminder over the server limit: FAILED, expected a server limit error but got %v", err)
+	}
+
+	// no limits, e.g. for an admin
+	if _, err := createReminder(ctx, store, nil, testReminderMessage("stretch 1h", sent), reminderOptions{}); err != nil {
+		t.Errorf("createReminder without limits: FAILED, unexpected error %v", err)
+	}
+}
+
+func TestReminderLimitsOnEditAndSnooze(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	sent := time.Now().Truncate(time.Millisecond)
+	limits := reminderLimits{maxPerUser: 1, maxHorizon: 7 * 24 * time.Hour, maxLength: 20}
+
+	r, _ := store.Create(ctx, Reminder{Due: sent.Add(time.Hour), Server: "1001", Creator: "4001", Recipients: []string{"4002"}, Message: "stretch", BotSource: reminderBotSource})
+
+	for _, content := range []string{"edit " + r.ID + " 8d", "edit " + r.ID + " this reminder message is far too long"} {
+		if _, err := editReminder(ctx, store, nil, testReminderMessage(content, sent), reminderOptions{limits: limits}); err == nil {
+			t.Errorf("editReminder %q: FAILED, expected it to be over a limit", content)
+		}
+	}
+	if got, _ := store.Get(ctx, r.ID); !got.Due.Equal(r.Due) || got.Message != "stretch" {
+		t.Errorf("editReminder over a limit: FAILED, expected the reminder to be left alone but got %v %q", got.Due, got.Message)
+	}
+	if _, err := editReminder(ctx, store, nil, testReminderMessage("edit "+r.ID+" 6d", sent), reminderOptions{limits: limits}); err != nil {
+		t.Errorf("editReminder within the limits: FAILED, unexpected error %v", err)
+	}
+
+	// a recipient snoozing makes a copy of their own, which counts against their limit
+	// rather than the creator's
+	if _, err := snoozeReminder(ctx, store, nil, r.ID, "4002", nil, "10m", sent, limits); err != nil {
+		t.Errorf("snoozeReminder within the user limit: FAILED, unexpected error %v", err)
+	}
+	if _, err := snoozeReminder(ctx, store, nil, r.ID, "4002", nil, "10m", sent, limits); err == nil {
+		t.Errorf("snoozeReminder over the user limit: FAILED, expected an error")
+	}
+	if _, err := snoozeReminder(ctx, store, nil, r.ID, "4002", nil, "10m", sent, reminderLimits{}); err != nil {
+		t.Errorf("snoozeReminder without limits: FAILED, unexpected error %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// reminderEmoji is the reaction that offers to set a reminder about a message,
+// REMINDER_EMOJI (default ⏰)
+func reminderEmoji() string {
+	return envOrDefault("REMINDER_EMOJI", "⏰")
+}
+
+const (
+	promptCustomIDPrefix = "remindme:prompt:"
+	reminderPromptText   = "When should I remind you about"
+)
+
+// reminderPromptTimes are the quick picks offered when prompting for a reminder time
+var reminderPromptTimes = []struct {
+	label string
+	value string
+}{
+	{"In 1h", "1h"},
+	{"In 3h", "3h"},
+	{"Tomorrow", "tomorrow"},
+}
+
+var messageLinkPattern = regexp.MustCompile(`https://discord\.com/channels/(\d+|@me)/(\d+)/(\d+)`)
+
+// messageLink links to a message, which is in a DM if there's no guild
+func messageLink(guild string, channel string, message string) string {
+	if guild == "" {
+		guild = "@me"
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guild, channel, message)
+}
+
+// reminderPrompt asks someone who reacted to a message when to remind them about it. The
+// prompt carries the link to the message, so answering it by button or reply doesn't
+// need any state kept between the two.
+func reminderPrompt(guild string, channel string, message string) *discordgo.MessageSend {
+	var buttons []discordgo.MessageComponent
+	for _, t := range reminderPromptTimes {
+		buttons = append(buttons, discordgo.Button{
+			Label:    t.label,
+			Style:    discordgo.SecondaryButton,
+			CustomID: promptCustomIDPrefix + t.value,
+		})
+	}
+
+	return &discordgo.MessageSend{
+		Content:    fmt.Sprintf("%s %s? Pick a time or reply to this message with one, like `2h` or `friday at 5pm`.", reminderPromptText, messageLink(guild, channel, message)),
+		Components: []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}},
+	}
+}
+
+// promptedReminder turns an answer to a reminder prompt into a remindme command replying
+// to the message the prompt was about. It's sent by DM unless the answer picks a target.
+func promptedReminder(prompt *discordgo.Message, user *discordgo.User, answer string, now time.Time) (*discordgo.Message, error) {
+	m := messageLinkPattern.FindStringSubmatch(prompt.Content)
+	if m == nil {
+		return nil, fmt.Errorf("That isn't a reminder prompt")
+	}
+
+	guild := m[1]
+	if guild == "@me" {
+		guild = ""
+	}
+
+	answer = strings.TrimSpace(answer)
+	if target, _, _ := parseTarget(answer); target == "" {
+		answer = "dm " + answer
+	}
+
+	return &discordgo.Message{
+		ChannelID: m[2],
+		GuildID:   guild,
+		Content:   answer,
+		Timestamp: now,
+		Author:    user,
+		MessageReference: &discordgo.MessageReference{
+			MessageID: m[3],
+			ChannelID: m[2],
+			GuildID:   guild,
+		},
+	}, nil
+}
+
+// isReminderPrompt reports whether a message is a reminder prompt sent by the bot
+func isReminderPrompt(m *discordgo.Message, botID string) bool {
+	return m != nil && m.Author != nil && m.Author.ID == botID && strings.HasPrefix(m.Content, reminderPromptText)
+}
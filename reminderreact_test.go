@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPromptedReminder(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	now := time.Now().Truncate(time.Millisecond)
+	user := &discordgo.User{ID: "4001", Username: "chris"}
+
+	send := reminderPrompt("1001", "2001", "3001")
+	prompt := &discordgo.Message{Content: send.Content, Author: &discordgo.User{ID: "9001"}}
+	if !isReminderPrompt(prompt, "9001") {
+		t.Fatalf("isReminderPrompt: FAILED, expected %q to be a prompt", prompt.Content)
+	}
+	if isReminderPrompt(&discordgo.Message{Content: send.Content, Author: user}, "9001") {
+		t.Errorf("isReminderPrompt: FAILED, expected a message not from the bot not to be a prompt")
+	}
+
+	tests := []struct {
+		answer string
+		target string
+	}{
+		{"2h", reminderTargetDM},
+		{"here 2h", reminderTargetSource},
+	}
+
+	for _, tc := range tests {
+		message, err := promptedReminder(prompt, user, tc.answer, now)
+		if err != nil {
+			t.Fatalf("promptedReminder %q: FAILED, unexpected error %v", tc.answer, err)
+		}
+
+		store = newMemoryReminderStore()
+		if _, err := createReminder(ctx, store, nil, message, reminderOptions{}); err != nil {
+			t.Fatalf("createReminder %q: FAILED, unexpected error %v", tc.answer, err)
+		}
+
+		reminders, _ := store.ListByUser(ctx, "1001", "4001", now)
+		if len(reminders) != 1 {
+			t.Fatalf("createReminder %q: FAILED, expected 1 reminder but got %d", tc.answer, len(reminders))
+		}
+		r := reminders[0]
+		if r.SourceMessage != "3001" || r.Channel != "2001" || r.Target != tc.target {
+			t.Errorf("createReminder %q: FAILED, expected a %s reminder about 2001/3001 but got %+v", tc.answer, tc.target, r)
+		}
+		if expected := "https://discord.com/channels/1001/2001/3001"; r.Message != expected {
+			t.Errorf("createReminder %q: FAILED, expected %v but got %v", tc.answer, expected, r.Message)
+		}
+	}
+
+	// every example the prompt gives works as an answer, and keeps the message quoted
+	examples := regexp.MustCompile("`([^`]+)`").FindAllStringSubmatch(send.Content, -1)
+	if len(examples) == 0 {
+		t.Fatalf("reminderPrompt: FAILED, expected example answers in %q", send.Content)
+	}
+	for _, example := range examples {
+		message, _ := promptedReminder(prompt, user, example[1], now)
+		store = newMemoryReminderStore()
+		if _, err := createReminder(ctx, store, nil, message, reminderOptions{}); err != nil {
+			t.Errorf("createReminder %q: FAILED, unexpected error %v", example[1], err)
+			continue
+		}
+		reminders, _ := store.ListByUser(ctx, "1001", "4001", now)
+		if len(reminders) != 1 || reminders[0].Message != "https://discord.com/channels/1001/2001/3001" {
+			t.Errorf("createReminder %q: FAILED, expected a reminder quoting the message but got %v", example[1], reminders)
+			continue
+		}
+		if due := reminders[0].Due; example[1] == "friday at 5pm" && (due.Weekday() != time.Friday || due.Hour() != 17 || due.Sub(now) > 7*24*time.Hour) {
+			t.Errorf("createReminder %q: FAILED, expected the coming Friday at 17:00 but got %v", example[1], due)
+		}
+	}
+
+	if _, err := promptedReminder(&discordgo.Message{Content: "hello"}, user, "2h", now); err == nil {
+		t.Errorf("promptedReminder without a link: FAILED, expected an error")
+	}
+}
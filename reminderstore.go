@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+	"github.com/honeycombio/beeline-go"
+)
+
+const reminderBotSource = "GoDiscordBot"
+
+// ReminderStore persists reminders for the remindme command and the reminder sender
+type ReminderStore interface {
+	// Create stores a new reminder, assigning it an ID if it doesn't have one
+	Create(ctx context.Context, r Reminder) (Reminder, error)
+	// Get returns the reminder with the ID
+	Get(ctx context.Context, id string) (Reminder, error)
+	// ListByUser returns the user's reminders on the server that are due after from
+	ListByUser(ctx context.Context, server string, creator string, from time.Time) ([]Reminder, error)
+	// ListByCreator returns the user's reminders on every server that are due after from
+	ListByCreator(ctx context.Context, creator string, from time.Time) ([]Reminder, error)
+	// ListByGuild returns every reminder on the server that is due after from
+	ListByGuild(ctx context.Context, server string, from time.Time) ([]Reminder, error)
+	// CountPending returns how many pending reminders the user has created on any server,
+	// or everyone has created on the server if creator is empty
+	CountPending(ctx context.Context, server string, creator string) (int, error)
+	// Due returns pending reminders due between start and end, skipping any that haven't
+	// been given an ID and status by the migrations yet
+	Due(ctx context.Context, start time.Time, end time.Time) ([]Reminder, error)
+	// Claim marks a pending reminder as being sent by holder until the claim expires,
+	// reporting false if another instance has claimed it or it has changed since r was read
+	Claim(ctx context.Context, r Reminder, holder string, until time.Time) (bool, error)
+	// MarkDelivered records that a reminder has been sent
+	MarkDelivered(ctx context.Context, id string) error
+	// RecordFailure records a failed delivery attempt. The reminder stays pending until
+	// retryAt, or is marked failed if retryAt is zero.
+	RecordFailure(ctx context.Context, id string, attempts int, lastError string, retryAt time.Time) error
+	// Reschedule moves a reminder to a new due time, making it pending again
+	Reschedule(ctx context.Context, id string, due time.Time) error
+	// SetMessage replaces a reminder's text
+	SetMessage(ctx context.Context, id string, message string) error
+	// Delete removes a reminder
+	Delete(ctx context.Context, id string) error
+	// DeleteFinished removes delivered and failed reminders that were due before before
+	DeleteFinished(ctx context.Context, before time.Time) (int, error)
+	// DeleteByCreator removes every reminder the user created, on any server
+	DeleteByCreator(ctx context.Context, creator string) (int, error)
+	// ListByRecipient returns every reminder the user is a recipient of, in any state
+	ListByRecipient(ctx context.Context, user string) ([]Reminder, error)
+	// SetRecipients replaces the users a reminder is for
+	SetRecipients(ctx context.Context, id string, recipients []string) error
+}
+
+// newReminderStore stores reminders in REMINDER_COLLECTION (default reminders) of the database
+func newReminderStore(db gosmosdb.Database) ReminderStore {
+	return &reminderRepository{
+		repo: gosmosdb.NewRepository[Reminder](db.Collection(reminderCollection())),
+	}
+}
+
+func reminderCollection() string {
+	return envOrDefault("REMINDER_COLLECTION", "reminders")
+}
+
+const reminderIDAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// newReminderID returns a short random ID that is easy to type back into a command
+func newReminderID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = reminderIDAlphabet[int(b[i])%len(reminderIDAlphabet)]
+	}
+	return string(b), nil
+}
+
+// reminderRepository stores reminders in a gosmosdb repository
+type reminderRepository struct {
+	repo gosmosdb.Repository[Reminder]
+}
+
+// newMemoryReminderStore keeps reminders in memory, for tests and running without a database
+func newMemoryReminderStore() *reminderRepository {
+	return &reminderRepository{
+		repo: gosmosdb.NewRepository[Reminder](gosmosdb.NewMemoryDatabase().Collection("reminders")),
+	}
+}
+
+func (s *reminderRepository) Create(ctx context.Context, r Reminder) (Reminder, error) {
+	if r.ID == "" {
+		id, err := newReminderID()
+		if err != nil {
+			return Reminder{}, err
+		}
+		r.ID = id
+	}
+	if r.Status == "" {
+		r.Status = reminderPending
+	}
+	r.SchemaVersion = reminderSchemaVersion
+
+	if err := s.repo.Insert(ctx, r); err != nil {
+		return Reminder{}, err
+	}
+
+	return r, nil
+}
+
+func (s *reminderRepository) Get(ctx context.Context, id string) (Reminder, error) {
+	r, err := s.repo.FindOne(ctx, gosmosdb.Where(
+		gosmosdb.Eq("id", id),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+	if err != nil {
+		return Reminder{}, fmt.Errorf("reminder %s: %w", id, err)
+	}
+	return r, nil
+}
+
+func (s *reminderRepository) ListByUser(ctx context.Context, server string, creator string, from time.Time) ([]Reminder, error) {
+	return s.find(ctx, gosmosdb.Where(
+		gosmosdb.Gt("due", from),
+		gosmosdb.Eq("server", server),
+		gosmosdb.Eq("creator", creator),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+}
+
+func (s *reminderRepository) ListByCreator(ctx context.Context, creator string, from time.Time) ([]Reminder, error) {
+	return s.find(ctx, gosmosdb.Where(
+		gosmosdb.Gt("due", from),
+		gosmosdb.Eq("creator", creator),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+}
+
+func (s *reminderRepository) ListByGuild(ctx context.Context, server string, from time.Time) ([]Reminder, error) {
+	return s.find(ctx, gosmosdb.Where(
+		gosmosdb.Gt("due", from),
+		gosmosdb.Eq("server", server),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+}
+
+func (s *reminderRepository) Due(ctx context.Context, start time.Time, end time.Time) ([]Reminder, error) {
+	// reminders from before IDs and statuses can't be claimed or marked delivered, so
+	// they're left until the migrations have backfilled them
+	return s.find(ctx, gosmosdb.Where(
+		gosmosdb.Gt("due", start),
+		gosmosdb.Lt("due", end),
+		gosmosdb.Eq("status", reminderPending),
+		gosmosdb.Gt("id", ""),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+}
+
+func (s *reminderRepository) CountPending(ctx context.Context, server string, creator string) (int, error) {
+	conditions := []gosmosdb.Condition{pendingReminder(), gosmosdb.Eq("botsource", reminderBotSource)}
+	if creator != "" {
+		conditions = append(conditions, gosmosdb.Eq("creator", creator))
+	} else {
+		conditions = append(conditions, gosmosdb.Eq("server", server))
+	}
+
+	n, err := s.repo.Count(ctx, gosmosdb.Where(conditions...))
+	return int(n), err
+}
+
+// pendingReminder matches reminders waiting to be sent, including ones stored before
+// reminders had a status
+func pendingReminder() gosmosdb.Condition {
+	return gosmosdb.In("status", reminderPending, "", nil)
+}
+
+func (s *reminderRepository) Claim(ctx context.Context, r Reminder, holder string, until time.Time) (bool, error) {
+	update := gosmosdb.Update{"claimedBy": holder, "claimedUntil": until}
+	claimable := func(claim gosmosdb.Condition) gosmosdb.Filter {
+		// due is stored to the millisecond, and matching it skips a stale copy of a
+		// reminder that has since been moved
+		return gosmosdb.Where(
+			gosmosdb.Eq("id", r.ID),
+			gosmosdb.Eq("due", r.Due.Truncate(time.Millisecond)),
+			pendingReminder(),
+			claim,
+		)
+	}
+
+	// never claimed, or the last claim has expired
+	n, err := s.repo.Update(ctx, claimable(gosmosdb.Eq("claimedUntil", nil)), update)
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+	n, err = s.repo.Update(ctx, claimable(gosmosdb.Lt("claimedUntil", time.Now())), update)
+	return n > 0, err
+}
+
+func (s *reminderRepository) MarkDelivered(ctx context.Context, id string) error {
+	return s.update(ctx, id, gosmosdb.Update{"status": reminderDelivered, "lastError": ""})
+}
+
+func (s *reminderRepository) RecordFailure(ctx context.Context, id string, attempts int, lastError string, retryAt time.Time) error {
+	update := gosmosdb.Update{
+		"attempts":     attempts,
+		"lastError":    lastError,
+		"claimedUntil": time.Time{},
+	}
+	if retryAt.IsZero() {
+		update["status"] = reminderFailed
+	} else {
+		update["status"] = reminderPending
+		update["nextAttempt"] = retryAt
+	}
+
+	return s.update(ctx, id, update)
+}
+
+func (s *reminderRepository) Reschedule(ctx context.Context, id string, due time.Time) error {
+	return s.update(ctx, id, gosmosdb.Update{
+		"due":          due,
+		"status":       reminderPending,
+		"attempts":     0,
+		"lastError":    "",
+		"nextAttempt":  time.Time{},
+		"claimedUntil": time.Time{},
+	})
+}
+
+func (s *reminderRepository) SetMessage(ctx context.Context, id string, message string) error {
+	return s.update(ctx, id, gosmosdb.Update{"message": message})
+}
+
+func (s *reminderRepository) Delete(ctx context.Context, id string) error {
+	n, err := s.repo.Delete(ctx, gosmosdb.Where(gosmosdb.Eq("id", id)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("reminder %s: %w", id, gosmosdb.ErrNotFound)
+	}
+	return nil
+}
+
+func (s *reminderRepository) DeleteFinished(ctx context.Context, before time.Time) (int, error) {
+	n, err := s.repo.Delete(ctx, gosmosdb.Where(
+		gosmosdb.In("status", reminderDelivered, reminderFailed),
+		gosmosdb.Lt("due", before),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+	return int(n), err
+}
+
+func (s *reminderRepository) DeleteByCreator(ctx context.Context, creator string) (int, error) {
+	n, err := s.repo.Delete(ctx, gosmosdb.Where(
+		gosmosdb.Eq("creator", creator),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+	return int(n), err
+}
+
+func (s *reminderRepository) ListByRecipient(ctx context.Context, user string) ([]Reminder, error) {
+	return s.find(ctx, gosmosdb.Where(
+		gosmosdb.Eq("recipients", user),
+		gosmosdb.Eq("botsource", reminderBotSource),
+	))
+}
+
+func (s *reminderRepository) SetRecipients(ctx context.Context, id string, recipients []string) error {
+	return s.update(ctx, id, gosmosdb.Update{"recipients": recipients})
+}
+
+func (s *reminderRepository) update(ctx context.Context, id string, update gosmosdb.Update) error {
+	n, err := s.repo.Update(ctx, gosmosdb.Where(gosmosdb.Eq("id", id)), update)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("reminder %s: %w", id, gosmosdb.ErrNotFound)
+	}
+	return nil
+}
+
+func (s *reminderRepository) find(ctx context.Context, filter gosmosdb.Filter) ([]Reminder, error) {
+	ctx, span := beeline.StartSpan(ctx, "reminderRepository.find")
+	defer span.Send()
+
+	reminders, err := s.repo.Find(ctx, filter, gosmosdb.SortBy("due", false))
+	if err != nil {
+		span.AddField("reminderRepository.find.error", err)
+		return nil, err
+	}
+
+	span.AddField("reminderRepository.find.count", len(reminders))
+
+	return reminders, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockPattern matches a time of day like 17:00, 5pm or 5:30pm. A bare hour needs am or
+// pm so it isn't confused with a count.
+const clockPattern = `(\d{1,2})(?::(\d{2})\s*(am|pm)?|\s*(am|pm))`
+
+var (
+	clockRegexp    = regexp.MustCompile(`(?i)^` + clockPattern + `$`)
+	atClockPattern = regexp.MustCompile(`(?i)\bat\s+` + clockPattern + `\b`)
+	datePattern    = regexp.MustCompile(`(?i)\b(?:on\s+)?(\d{4}-\d{2}-\d{2})(?:\s+(?:at\s+)?(` + clockPattern + `))?\b`)
+	dayPattern     = regexp.MustCompile(`(?i)\b(today|tonight|tomorrow)\b(?:\s+(?:at\s+)?(` + clockPattern + `))?`)
+	nextDayPattern = regexp.MustCompile(`(?i)\b(?:next|on)\s+(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b(?:\s+(?:at\s+)?(` + clockPattern + `))?`)
+	// a weekday without next or on needs a time, so "friday" alone stays in the message
+	weekdayClockPattern = regexp.MustCompile(`(?i)\b(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\s+(?:at\s+)?(` + clockPattern + `)`)
+	weekdayPattern      = regexp.MustCompile(`(?i)\b(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+)
+
+// defaultClockTime is used for a day without a time, e.g. "tomorrow"
+const defaultClockTime = "09:00"
+
+// parseClock reads a time of day, returning the hour and minute
+func parseClock(text string) (int, int, error) {
+	m := clockRegexp.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return 0, 0, fmt.Errorf("%s isn't a time of day", text)
+	}
+
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	meridiem := strings.ToLower(m[3] + m[4])
+
+	switch {
+	case meridiem != "" && (hour < 1 || hour > 12):
+		return 0, 0, fmt.Errorf("%s isn't a time of day", text)
+	case meridiem == "am" && hour == 12:
+		hour = 0
+	case meridiem == "pm" && hour != 12:
+		hour += 12
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("%s isn't a time of day", text)
+	}
+
+	return hour, minute, nil
+}
+
+// atClock returns the day of t at the time of day in clock, or at the default time if
+// clock is empty
+func atClock(t time.Time, clock string) (time.Time, error) {
+	if clock == "" {
+		clock = defaultClockTime
+	}
+	hour, minute, err := parseClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, t.Location()), nil
+}
+
+// edgeMatch finds pattern at the start or end of text, so a day mentioned in the middle of
+// the message, like "submit timesheet today 2h", stays part of it
+func edgeMatch(pattern *regexp.Regexp, text string) []string {
+	trimmed := strings.TrimRight(strings.TrimSpace(text), ".!?")
+	for _, idx := range pattern.FindAllStringSubmatchIndex(trimmed, -1) {
+		if idx[0] != 0 && idx[1] != len(trimmed) {
+			continue
+		}
+		m := make([]string, len(idx)/2)
+		for i := range m {
+			if idx[2*i] >= 0 {
+				m[i] = trimmed[idx[2*i]:idx[2*i+1]]
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// parseReminderTime looks for an absolute time at the start or end of text, like "at 5pm",
+// "tomorrow 9am", "on 2026-12-24 18:00", "next friday" or "friday at 5pm", read in loc.
+// It returns the time, the text with the time removed, or false if there's no time.
+func parseReminderTime(text string, from time.Time, loc *time.Location) (time.Time, string, bool, error) {
+	now := from.In(loc)
+
+	if m := edgeMatch(datePattern, text); m != nil {
+		day, err := time.ParseInLocation("2006-01-02", m[1], loc)
+		if err != nil {
+			return time.Time{}, text, false, fmt.Errorf("%s isn't a date", m[1])
+		}
+		due, err := atClock(day, m[2])
+		if err != nil {
+			return time.Time{}, text, false, err
+		}
+		return futureTime(due, now, strings.Replace(text, m[0], "", 1))
+	}
+
+	if m := edgeMatch(dayPattern, text); m != nil {
+		day := now
+		clock := m[2]
+		switch strings.ToLower(m[1]) {
+		case "tomorrow":
+			day = now.AddDate(0, 0, 1)
+		case "tonight":
+			if clock == "" {
+				clock = "20:00"
+			}
+		}
+		text = strings.Replace(text, m[0], "", 1)
+		if clock == "" {
+			if at := atClockPattern.FindStringSubmatch(text); at != nil {
+				clock = strings.TrimSpace(at[0][2:])
+				text = strings.Replace(text, at[0], "", 1)
+			}
+		}
+		due, err := atClock(day, clock)
+		if err != nil {
+			return time.Time{}, text, false, err
+		}
+		return futureTime(due, now, text)
+	}
+
+	m := edgeMatch(nextDayPattern, text)
+	soonest := false
+	if m == nil {
+		// "friday at 5pm" is the soonest friday at 5pm, which can be today
+		m = edgeMatch(weekdayClockPattern, text)
+		soonest = m != nil
+	}
+	if m != nil {
+		weekday := weekdayNames[strings.ToLower(m[1])]
+		days := (int(weekday) - int(now.Weekday()) + 7) % 7
+		if days == 0 && !soonest {
+			days = 7
+		}
+		due, err := atClock(now.AddDate(0, 0, days), m[2])
+		if err != nil {
+			return time.Time{}, text, false, err
+		}
+		if !due.After(now) {
+			due = due.AddDate(0, 0, 7)
+		}
+		return futureTime(due, now, strings.Replace(text, m[0], "", 1))
+	}
+
+	if m := edgeMatch(atClockPattern, text); m != nil {
+		due, err := atClock(now, strings.TrimSpace(m[0][2:]))
+		if err != nil {
+			return time.Time{}, text, false, err
+		}
+		// a day at the other end, like "friday post memes at 5pm", isn't meant as today
+		if day := edgeMatch(weekdayPattern, strings.Replace(text, m[0], "", 1)); day != nil {
+			return time.Time{}, text, false, fmt.Errorf("Put the day next to the time, like %s at %s", strings.ToLower(day[1]), strings.TrimSpace(m[0][2:]))
+		}
+		// a time that's already passed today means tomorrow
+		if !due.After(now) {
+			due = due.AddDate(0, 0, 1)
+		}
+		return due, strings.Replace(text, m[0], "", 1), true, nil
+	}
+
+	return time.Time{}, text, false, nil
+}
+
+func futureTime(due time.Time, now time.Time, text string) (time.Time, string, bool, error) {
+	if !due.After(now) {
+		return time.Time{}, text, false, fmt.Errorf("<t:%d:F> is in the past", due.Unix())
+	}
+	return due, text, true, nil
+}
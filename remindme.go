@@ -2,212 +2,484 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
 	"github.com/honeycombio/beeline-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Reminder struct {
-	Due             time.Time `json:"due" bson:"due"`
-	Message         string    `json:"message" bson:"message"`
-	Server          string    `json:"server" bson:"server"`
-	Creator         string    `json:"creator" bson:"creator"`
-	Channel         string    `json:"channel" bson:"channel"`
-	SourceMessage   string    `json:"sourceMessage" bson:"sourceMessage"`
-	SourceTimestamp time.Time `json:"sourceTimestamp" bson:"sourceTimestamp"`
-	BotSource       string    `json:"botsource" bson:"botsource"`
-}
-
-func sendReminders(session *discordgo.Session) {
-	storage := os.Getenv("COSMOSDB_URI")
+	ID              string      `json:"id" bson:"id"`
+	Due             time.Time   `json:"due" bson:"due"`
+	Message         string      `json:"message" bson:"message"`
+	Server          string      `json:"server" bson:"server"`
+	Creator         string      `json:"creator" bson:"creator"`
+	Recipients      []string    `json:"recipients,omitempty" bson:"recipients,omitempty"`
+	Roles           []string    `json:"roles,omitempty" bson:"roles,omitempty"`
+	Channel         string      `json:"channel" bson:"channel"`
+	Target          string      `json:"target,omitempty" bson:"target,omitempty"`
+	TargetChannel   string      `json:"targetChannel,omitempty" bson:"targetChannel,omitempty"`
+	SourceMessage   string      `json:"sourceMessage" bson:"sourceMessage"`
+	SourceTimestamp time.Time   `json:"sourceTimestamp" bson:"sourceTimestamp"`
+	BotSource       string      `json:"botsource" bson:"botsource"`
+	Status          string      `json:"status" bson:"status"`
+	Attempts        int         `json:"attempts" bson:"attempts"`
+	LastError       string      `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	NextAttempt     time.Time   `json:"nextAttempt,omitempty" bson:"nextAttempt,omitempty"`
+	ClaimedBy       string      `json:"claimedBy,omitempty" bson:"claimedBy,omitempty"`
+	ClaimedUntil    time.Time   `json:"claimedUntil,omitempty" bson:"claimedUntil,omitempty"`
+	Recurrence      *Recurrence `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	SchemaVersion   int         `json:"schemaVersion" bson:"schemaVersion"`
+}
+
+// reminderSchemaVersion is the version of the Reminder document written by this code.
+// Older documents are brought up to date by the migrations in migrations.go.
+const reminderSchemaVersion = 1
+
+// Reminder delivery states
+const (
+	reminderPending   = "pending"
+	reminderDelivered = "delivered"
+	reminderFailed    = "failed"
+)
+
+// Reminder delivery targets. An empty target means the source channel.
+const (
+	reminderTargetSource  = "source"
+	reminderTargetDM      = "dm"
+	reminderTargetChannel = "channel"
+)
+
+// fireAt is when the reminder should next be sent, which is later than Due while a
+// failed delivery is waiting to be retried
+func (r Reminder) fireAt() time.Time {
+	if r.NextAttempt.After(r.Due) {
+		return r.NextAttempt
+	}
+	return r.Due
+}
+
+// reminderInterval is how often the scheduler reloads reminders from the store,
+// REMINDER_INTERVAL minutes with a default of 5
+func reminderInterval() time.Duration {
 	interval, err := strconv.Atoi(os.Getenv("REMINDER_INTERVAL"))
-	if err != nil {
+	if err != nil || interval <= 0 {
 		interval = 5
 	}
-	for {
-		time.Sleep(time.Duration(interval) * time.Minute)
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	return time.Duration(interval) * time.Minute
+}
 
-		ctx, span := beeline.StartSpan(ctx, "sendReminders")
+// reminderLocation is the message author's registered timezone, defaulting to UTC
+func reminderLocation(ctx context.Context, session *discordgo.Session, settings UserSettingsStore, message *discordgo.Message) *time.Location {
+	zones := memberTimezones{settings: settings, session: session}
+	loc, err := zones.location(ctx, message.GuildID, message.Author)
+	if err != nil {
+		return time.UTC
+	}
+	beeline.AddField(ctx, "reminder.timezone", loc.String())
+	return loc
+}
 
-		db, err := connectDb(ctx, storage)
-		if err != nil {
-			span.AddField("sendReminders.connect.error", err)
-			span.Send()
-			continue
-		}
+// reminderOptions is what createReminder needs to know about the author beyond their message
+type reminderOptions struct {
+	// loc is the author's timezone, defaulting to UTC
+	loc *time.Location
+	// canMentionRoles allows the author to remind whole roles
+	canMentionRoles bool
+	// settings are checked for recipients who've opted out of other people's reminders
+	// and for the author's default delivery target
+	settings UserSettingsStore
+	// canPostIn reports whether the author can post in a channel chosen for delivery,
+	// allowing any channel if nil
+	canPostIn func(channelID string) bool
+	// limits stop the author creating too many reminders, or ones too far ahead, too
+	// frequent or too long
+	limits reminderLimits
+}
 
-		reminders, err := findReminders(ctx, db, interval)
-		if err != nil {
-			span.AddField("sendReminders.find.error", err)
-			span.Send()
-			continue
-		}
+func createReminder(ctx context.Context, store ReminderStore, scheduler *reminderScheduler, message *discordgo.Message, opts reminderOptions) (string, error) {
 
-		for _, r := range reminders {
-			ctx, childSpan := beeline.StartSpan(ctx, "sendReminderIndividual")
-			childSpan.AddField("sendReminderIndividual.due", r.Due)
-			childSpan.AddField("sendReminderIndividual.message", r.Message)
-			childSpan.AddField("sendReminderIndividual.server", r.Server)
-			childSpan.AddField("sendReminderIndividual.creator", r.Creator)
-			childSpan.AddField("sendReminderIndividual.channel", r.Channel)
-			childSpan.AddField("sendReminderIndividual.sourceMessage", r.SourceMessage)
-			childSpan.AddField("sendReminderIndividual.sourceTimestamp", r.SourceTimestamp)
-			childSpan.AddField("sendReminderIndividual.botSource", r.BotSource)
+	ctx, span := beeline.StartSpan(ctx, "createReminder")
+	defer span.Send()
 
-			message := fmt.Sprintf("Hey <@%s>, remember %s", r.Creator, r.Message)
+	if opts.loc == nil {
+		opts.loc = time.UTC
+	}
 
-			messageReference := &discordgo.MessageReference{
-				MessageID: r.SourceMessage,
-				ChannelID: r.Channel,
-				GuildID:   r.Server,
-			}
+	r, err := parseReminder(ctx, message, opts.loc)
+	if err != nil {
+		span.AddField("createReminder.error", err)
+		return "", err
+	}
 
-			sendReply(ctx, session, message, messageReference)
+	if len(r.Roles) > 0 && !opts.canMentionRoles {
+		err := fmt.Errorf("You don't have permission to set reminders for roles")
+		span.AddField("createReminder.error", err)
+		return "", err
+	}
 
-			childSpan.Send()
+	if err := opts.limits.check(ctx, store, r, message.Timestamp); err != nil {
+		span.AddField("createReminder.limit", err)
+		return "", err
+	}
+
+	if r.Target == "" && opts.settings != nil {
+		settings, err := opts.settings.Get(ctx, r.Creator)
+		if err != nil {
+			span.AddField("createReminder.error", err)
+			return "", err
 		}
+		r.Target, r.TargetChannel = settings.DefaultTarget, settings.DefaultTargetChannel
+	}
+	if err := checkReminderTarget(r, opts); err != nil {
+		span.AddField("createReminder.error", err)
+		return "", err
+	}
+	span.AddField("createReminder.target", r.Target)
 
-		err = db.Disconnect(ctx)
+	var note string
+	if len(r.Recipients) > 0 {
+		out, err := optedOut(ctx, opts.settings, r.Creator, r.Recipients)
 		if err != nil {
-			span.AddField("sendReminders.find.error", err)
-			span.Send()
-			continue
+			span.AddField("createReminder.error", err)
+			return "", err
+		}
+
+		var recipients, skipped []string
+		for _, user := range r.Recipients {
+			if out[user] {
+				skipped = append(skipped, "<@"+user+">")
+			} else {
+				recipients = append(recipients, user)
+			}
 		}
+		if len(skipped) > 0 {
+			note = fmt.Sprintf(" %s opted out of reminders from others so won't be reminded.", strings.Join(skipped, ", "))
+			if len(recipients) == 0 && len(r.Roles) == 0 {
+				return "", fmt.Errorf("%s", strings.TrimSpace(note))
+			}
+		}
+		r.Recipients = recipients
+		span.AddField("createReminder.optedOut", len(skipped))
+	}
+
+	r, err = storeReminder(ctx, store, r)
+	if err != nil {
+		span.AddField("createReminder.error", err)
+		return "", err
+	}
+
+	scheduler.Schedule(r)
 
-		span.Send()
+	if r.Recurrence != nil {
+		return fmt.Sprintf("Reminder %s added, repeating %s starting <t:%d:F>.%s", r.ID, r.Recurrence, r.Due.Unix(), note), nil
 	}
+	return fmt.Sprintf("Reminder %s added for <t:%d:F>.%s", r.ID, r.Due.Unix(), note), nil
 }
 
-func findReminders(ctx context.Context, db *mongo.Client, interval int) ([]Reminder, error) {
+// setReminderOptOut records whether the user wants reminders set by other people
+func setReminderOptOut(ctx context.Context, store UserSettingsStore, userID string, optOut bool) (string, error) {
 
-	ctx, span := beeline.StartSpan(ctx, "findReminders")
+	ctx, span := beeline.StartSpan(ctx, "setReminderOptOut")
 	defer span.Send()
 
-	start := time.Now().UnixNano() / 1000000
-	end := time.Now().Add(time.Duration(interval)*time.Minute).UnixNano() / 1000000
-	query := bson.M{
-		"due": bson.M{
-			"$gt": start,
-			"$lt": end,
-		},
-		"botsource": bson.M{
-			"$eq": "GoDiscordBot",
-		},
+	span.AddField("setReminderOptOut.optOut", optOut)
+
+	settings, err := store.Get(ctx, userID)
+	if err != nil {
+		span.AddField("setReminderOptOut.error", err)
+		return "", err
+	}
+
+	settings.OptOutOthers = optOut
+	if err := store.Save(ctx, settings); err != nil {
+		span.AddField("setReminderOptOut.error", err)
+		return "", err
+	}
+
+	if optOut {
+		return "Other people can no longer set reminders for you.", nil
+	}
+	return "Other people can set reminders for you again.", nil
+}
+
+// ownReminder fetches a reminder by ID for the author of the message, so people can only
+// change their own reminders
+func ownReminder(ctx context.Context, store ReminderStore, id string, message *discordgo.Message) (Reminder, error) {
+	r, err := store.Get(ctx, id)
+	// reminders from any server can be managed from a DM with the bot
+	if errors.Is(err, gosmosdb.ErrNotFound) || (err == nil && (r.Creator != message.Author.ID || (message.GuildID != "" && r.Server != message.GuildID))) {
+		return Reminder{}, fmt.Errorf("No reminder %s found, check the ID with !remindme list", id)
 	}
+	return r, err
+}
 
-	span.AddField("findReminders.query", query)
+func cancelReminder(ctx context.Context, store ReminderStore, scheduler *reminderScheduler, message *discordgo.Message) (string, error) {
+
+	ctx, span := beeline.StartSpan(ctx, "cancelReminder")
+	defer span.Send()
 
-	res, err := runQuery(ctx, db, query)
+	// expect message content to be like "cancel abc234", or "cancel abc234 next" to skip
+	// only the next occurrence of a recurring reminder
+	fields := strings.Fields(strings.TrimPrefix(message.Content, "cancel"))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("No reminder ID specified")
+	}
+	id := fields[0]
+	span.AddField("cancelReminder.id", id)
+
+	r, err := ownReminder(ctx, store, id, message)
 	if err != nil {
-		span.AddField("findReminders.error", err)
-		return nil, err
+		span.AddField("cancelReminder.error", err)
+		return "", err
 	}
 
-	var reminders []Reminder
-	for _, item := range res {
-		var r Reminder
+	if len(fields) > 1 && fields[1] == "next" {
+		if r.Recurrence == nil {
+			return "", fmt.Errorf("Reminder %s doesn't repeat, use !remindme cancel %s to cancel it", r.ID, r.ID)
+		}
+		span.AddField("cancelReminder.series", false)
 
-		temp, err := bson.Marshal(item)
-		if err != nil {
-			span.AddField("findReminders.error", err)
-			return nil, err
+		next, ok := r.Recurrence.next(r.Due)
+		if !ok {
+			if err := store.Delete(ctx, r.ID); err != nil {
+				span.AddField("cancelReminder.error", err)
+				return "", err
+			}
+			scheduler.Unschedule(r.ID)
+			return fmt.Sprintf("Reminder %s had no more occurrences and has been cancelled.", r.ID), nil
 		}
 
-		err = bson.Unmarshal(temp, &r)
-		if err != nil {
-			span.AddField("findReminders.error", err)
-			return nil, err
+		if err := store.Reschedule(ctx, r.ID, next); err != nil {
+			span.AddField("cancelReminder.error", err)
+			return "", err
 		}
+		r.Due = next
+		scheduler.Unschedule(r.ID)
+		scheduler.Schedule(r)
 
-		reminders = append(reminders, r)
+		return fmt.Sprintf("Skipped the next occurrence of reminder %s, it will next fire <t:%d:f>.", r.ID, next.Unix()), nil
 	}
+	span.AddField("cancelReminder.series", r.Recurrence != nil)
 
-	return reminders, nil
+	if err := store.Delete(ctx, r.ID); err != nil {
+		span.AddField("cancelReminder.error", err)
+		return "", err
+	}
+	scheduler.Unschedule(r.ID)
+
+	return fmt.Sprintf("Reminder %s cancelled.", r.ID), nil
 }
 
-func createReminder(ctx context.Context, message *discordgo.Message) (string, error) {
+func editReminder(ctx context.Context, store ReminderStore, scheduler *reminderScheduler, message *discordgo.Message, opts reminderOptions) (string, error) {
 
-	ctx, span := beeline.StartSpan(ctx, "createReminder")
+	ctx, span := beeline.StartSpan(ctx, "editReminder")
 	defer span.Send()
 
-	r, err := parseReminder(ctx, message)
+	// expect message content to be like "edit abc234 new text 2h", where either the
+	// text or the time can be left out
+	fields := strings.Fields(strings.TrimPrefix(message.Content, "edit"))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("Specify the reminder ID and new text or time, e.g. !remindme edit abc234 post memes 2h")
+	}
+	id := fields[0]
+	span.AddField("editReminder.id", id)
+
+	r, err := ownReminder(ctx, store, id, message)
 	if err != nil {
-		span.AddField("createReminder.error", err)
+		span.AddField("editReminder.error", err)
 		return "", err
 	}
 
-	err = storeReminder(ctx, r)
-	if err != nil {
-		span.AddField("createReminder.error", err)
+	if opts.loc == nil {
+		opts.loc = time.UTC
+	}
+
+	text := strings.Join(fields[1:], " ")
+
+	edited := r
+	due, remaining, err := parseDue(ctx, text, message.Timestamp, opts.loc)
+	rescheduled := err == nil
+	if rescheduled {
+		text = remaining
+		edited.Due = due
+		span.AddField("editReminder.due", due)
+	}
+	if text = strings.TrimSpace(text); text != "" {
+		edited.Message = text
+	}
+
+	if err := opts.limits.checkReminder(edited, message.Timestamp); err != nil {
+		span.AddField("editReminder.limit", err)
 		return "", err
 	}
 
-	return "Reminder added.", nil
+	if rescheduled {
+		if err := store.Reschedule(ctx, r.ID, due); err != nil {
+			span.AddField("editReminder.error", err)
+			return "", err
+		}
+		r.Due = due
+		r.Status = reminderPending
+		r.Attempts = 0
+		r.NextAttempt = time.Time{}
+	}
+
+	if text != "" {
+		if err := store.SetMessage(ctx, r.ID, text); err != nil {
+			span.AddField("editReminder.error", err)
+			return "", err
+		}
+		r.Message = text
+	}
+
+	scheduler.Unschedule(r.ID)
+	if r.Status == reminderPending {
+		scheduler.Schedule(r)
+	}
+
+	return fmt.Sprintf("Reminder %s updated, due <t:%d:F>.", r.ID, r.Due.Unix()), nil
 }
 
-func parseReminder(ctx context.Context, message *discordgo.Message) (Reminder, error) {
+// snoozeDue is when a reminder snoozed at now for the given button value should next fire
+func snoozeDue(now time.Time, value string) (time.Time, error) {
+	switch value {
+	case "tomorrow":
+		return now.AddDate(0, 0, 1), nil
+	default:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("Unknown snooze %s", value)
+		}
+		return now.Add(d), nil
+	}
+}
 
-	ctx, span := beeline.StartSpan(ctx, "parseReminder")
-	defer span.Send()
+// snoozeReminder reschedules a delivered reminder for the user who pressed a snooze
+// button, who must have set it, been sent it or have one of the role IDs it was sent to.
+// The creator snoozes the reminder itself unless it repeats, and anyone else snoozes a copy
+// of their own, which counts towards their limits like creating one does.
+func snoozeReminder(ctx context.Context, store ReminderStore, scheduler *reminderScheduler, id string, userID string, roleIDs []string, value string, now time.Time, limits reminderLimits) (string, error) {
 
-	// expect message content to be like:
-	// "do the thing 5h"
-	// "5d wrankle the sprockets"
-	// support minute, hour, day, Month
+	ctx, span := beeline.StartSpan(ctx, "snoozeReminder")
+	defer span.Send()
 
-	timePattern := regexp.MustCompile("(?P<count>\\d+)(?P<interval>m|[hH]|[dD]|M)")
-	names := timePattern.SubexpNames()
-	elements := map[string]string{}
-	matchingStrings := timePattern.FindAllStringSubmatch(message.Content, -1)
-	matches := []string{}
+	span.AddField("snoozeReminder.id", id)
+	span.AddField("snoozeReminder.value", value)
 
-	span.AddField("parseReminder.matcheslength", len(matchingStrings))
-	span.AddField("parseReminder.matches", matchingStrings)
+	r, err := store.Get(ctx, id)
+	if errors.Is(err, gosmosdb.ErrNotFound) || (err == nil && r.Creator != userID && !containsString(r.Recipients, userID) && !sharesRole(r.Roles, roleIDs)) {
+		return "Only the people this reminder was for can snooze it.", nil
+	} else if err != nil {
+		span.AddField("snoozeReminder.error", err)
+		return "", err
+	}
 
-	if len(matchingStrings) == 0 {
-		span.AddField("parseReminder.error", "No interval specified")
-		return Reminder{}, fmt.Errorf("No interval specified")
+	due, err := snoozeDue(now, value)
+	if err != nil {
+		span.AddField("snoozeReminder.error", err)
+		return "", err
 	}
 
-	matches = matchingStrings[0]
+	if r.Recurrence != nil || r.Creator != userID {
+		// snoozing one occurrence leaves the rest of the series alone, and a recipient
+		// snoozing only snoozes it for themselves, in a copy that's theirs
+		if r.Creator != userID {
+			r.Creator = userID
+			r.Recipients = []string{userID}
+			r.Roles = nil
+		}
+		r.ID = ""
+		r.Recurrence = nil
+		r.Due = due
+		r.Status = ""
+		r.Attempts = 0
+		r.LastError = ""
+		r.NextAttempt = time.Time{}
+		if err := limits.checkCount(ctx, store, r); err != nil {
+			span.AddField("snoozeReminder.limit", err)
+			return "", err
+		}
+		r, err = store.Create(ctx, r)
+		if err != nil {
+			span.AddField("snoozeReminder.error", err)
+			return "", err
+		}
+		scheduler.Schedule(r)
 
-	for i, match := range matches {
-		elements[names[i]] = match
+		span.AddField("snoozeReminder.copy", r.ID)
+		return fmt.Sprintf("Snoozed until <t:%d:f>.", due.Unix()), nil
 	}
 
-	span.AddField("parseReminder.count", elements["count"])
-	span.AddField("parseReminder.interval", elements["interval"])
+	if err := store.Reschedule(ctx, r.ID, due); err != nil {
+		span.AddField("snoozeReminder.error", err)
+		return "", err
+	}
 
-	reminderText := strings.Replace(message.Content, fmt.Sprintf("%s%s", elements["count"], elements["interval"]), "", 1)
+	r.Due = due
+	r.Status = reminderPending
+	r.Attempts = 0
+	r.NextAttempt = time.Time{}
+	scheduler.Unschedule(r.ID)
+	scheduler.Schedule(r)
+
+	span.AddField("snoozeReminder.due", due)
+
+	return fmt.Sprintf("Snoozed until <t:%d:f>.", due.Unix()), nil
+}
+
+func parseReminder(ctx context.Context, message *discordgo.Message, loc *time.Location) (Reminder, error) {
+
+	ctx, span := beeline.StartSpan(ctx, "parseReminder")
+	defer span.Send()
 
 	sourceDate := message.Timestamp
-	// if err != nil {
-	// 	span.AddField("parseReminder.error", err)
-	// 	return Reminder{}, err
-	// }
 
-	var dueDate time.Time
-	timeCount, _ := strconv.Atoi(elements["count"])
+	// the target can come before or after any mentions
+	target, targetChannel, content := parseTarget(message.Content)
+	recipients, roles, content := parseRecipients(content, message.Author.ID)
+	if target == "" {
+		target, targetChannel, content = parseTarget(content)
+	}
 
-	switch elements["interval"] {
-	case "m":
-		dueDate = sourceDate.Add(time.Duration(timeCount) * time.Minute)
-	case "h", "H":
-		dueDate = sourceDate.Add(time.Duration(timeCount) * time.Hour)
-	case "d", "D":
-		dueDate = sourceDate.AddDate(0, 0, timeCount)
-	case "M":
-		dueDate = sourceDate.AddDate(0, timeCount, 0)
+	var recurrence *Recurrence
+	rec, dueDate, reminderText, ok, err := parseRecurrence(content, sourceDate, loc)
+	if err != nil {
+		span.AddField("parseReminder.error", err)
+		return Reminder{}, err
+	}
+
+	if ok {
+		recurrence = &rec
+		span.AddField("parseReminder.recurrence", rec.String())
+	} else {
+		dueDate, reminderText, err = parseDue(ctx, content, sourceDate, loc)
+		if err != nil {
+			span.AddField("parseReminder.error", err)
+			return Reminder{}, err
+		}
+	}
+
+	// a reminder set in reply to a message is about that message, and is delivered as a
+	// reply to it rather than to the command
+	sourceMessage, channel := message.ID, message.ChannelID
+	if ref := message.MessageReference; ref != nil && ref.MessageID != "" {
+		sourceMessage = ref.MessageID
+		if ref.ChannelID != "" {
+			channel = ref.ChannelID
+		}
+		if strings.TrimSpace(reminderText) == "" {
+			reminderText = referencedText(message.ReferencedMessage, messageLink(message.GuildID, channel, sourceMessage))
+		}
+		span.AddField("parseReminder.reply", true)
 	}
 
 	r := Reminder{
@@ -215,215 +487,550 @@ func parseReminder(ctx context.Context, message *discordgo.Message) (Reminder, e
 		Message:         reminderText,
 		Server:          message.GuildID,
 		Creator:         message.Author.ID,
-		Channel:         message.ChannelID,
-		SourceMessage:   message.ID,
+		Recipients:      recipients,
+		Roles:           roles,
+		Channel:         channel,
+		Target:          target,
+		TargetChannel:   targetChannel,
+		SourceMessage:   sourceMessage,
 		SourceTimestamp: sourceDate,
-		BotSource:       "GoDiscordBot",
+		BotSource:       reminderBotSource,
+		Recurrence:      recurrence,
 	}
 
 	span.AddField("parseReminder.due", r.Due)
-	span.AddField("parseReminder.message", r.Message)
+	span.AddField("parseReminder.length", len(r.Message))
 	span.AddField("parseReminder.server", r.Server)
 	span.AddField("parseReminder.creator", r.Creator)
+	span.AddField("parseReminder.recipients", len(r.Recipients))
+	span.AddField("parseReminder.roles", len(r.Roles))
 	span.AddField("parseReminder.channel", r.Channel)
 	span.AddField("parseReminder.sourceMessage", r.SourceMessage)
 	span.AddField("parseReminder.sourceTimestamp", r.SourceTimestamp)
 	span.AddField("parseReminder.botSource", r.BotSource)
-
-	span.AddField("parseReminder.reminder", r)
 	return r, nil
 }
 
-func storeReminder(ctx context.Context, r Reminder) error {
+var leadingMentionPattern = regexp.MustCompile(`^\s*<@([!&]?)(\d+)>`)
 
-	ctx, span := beeline.StartSpan(ctx, "storeReminder")
+// parseRecipients takes the user and role mentions from the start of text, e.g. the
+// "@alice @team" in "@alice @team review the PR 1h". Mentions later in the text are left
+// as part of the message. Someone mentioning only themselves has no recipients.
+func parseRecipients(text string, creator string) ([]string, []string, string) {
+	var users, roles []string
+	seen := make(map[string]bool)
+
+	for {
+		m := leadingMentionPattern.FindStringSubmatch(text)
+		if m == nil {
+			break
+		}
+		text = text[len(m[0]):]
+
+		if seen[m[1]+m[2]] {
+			continue
+		}
+		seen[m[1]+m[2]] = true
+
+		if m[1] == "&" {
+			roles = append(roles, m[2])
+		} else {
+			users = append(users, m[2])
+		}
+	}
+
+	if len(users) == 1 && users[0] == creator {
+		users = nil
+	}
+
+	return users, roles, text
+}
+
+var targetPattern = regexp.MustCompile(`(?i)^\s*(?:(dm|here)\b|<#(\d+)>)`)
+
+// parseTarget takes where to deliver the reminder from the start of text: "dm" for a
+// direct message, "here" for the channel it was created in or a channel mention
+func parseTarget(text string) (string, string, string) {
+	m := targetPattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", "", text
+	}
+	text = text[len(m[0]):]
+
+	switch strings.ToLower(m[1]) {
+	case "dm":
+		return reminderTargetDM, "", text
+	case "here":
+		return reminderTargetSource, "", text
+	}
+	return reminderTargetChannel, m[2], text
+}
+
+// checkReminderTarget makes sure the reminder can be delivered where it's been sent
+func checkReminderTarget(r Reminder, opts reminderOptions) error {
+	switch r.Target {
+	case reminderTargetDM:
+		if len(r.Roles) > 0 {
+			return fmt.Errorf("Reminders for roles can't be sent by DM, pick a channel instead")
+		}
+	case reminderTargetChannel:
+		if opts.canPostIn != nil && !opts.canPostIn(r.TargetChannel) {
+			return fmt.Errorf("You can't post in <#%s> so reminders can't be sent there", r.TargetChannel)
+		}
+	}
+	return nil
+}
+
+// setReminderDefault saves where the user's reminders go when they don't pick a target,
+// e.g. "default dm", "default here" or "default #reminders"
+func setReminderDefault(ctx context.Context, store UserSettingsStore, message *discordgo.Message, opts reminderOptions) (string, error) {
+
+	ctx, span := beeline.StartSpan(ctx, "setReminderDefault")
 	defer span.Send()
-	span.AddField("storeReminder.reminder", r)
 
-	db, err := connectDb(ctx, os.Getenv("COSMOSDB_URI"))
+	target, channel, rest := parseTarget(strings.TrimPrefix(message.Content, "default"))
+	if target == "" || strings.TrimSpace(rest) != "" {
+		return "", fmt.Errorf("Choose dm, here or a channel, e.g. !remindme default dm")
+	}
+	span.AddField("setReminderDefault.target", target)
+
+	if err := checkReminderTarget(Reminder{Target: target, TargetChannel: channel}, opts); err != nil {
+		return "", err
+	}
+
+	settings, err := store.Get(ctx, message.Author.ID)
 	if err != nil {
-		span.AddField("storeReminder.error", err)
-		return err
+		span.AddField("setReminderDefault.error", err)
+		return "", err
 	}
 
-	err = writeDbObject(ctx, db, r)
+	settings.DefaultTarget, settings.DefaultTargetChannel = target, channel
+	if err := store.Save(ctx, settings); err != nil {
+		span.AddField("setReminderDefault.error", err)
+		return "", err
+	}
+
+	switch target {
+	case reminderTargetDM:
+		return "Your reminders will be sent by DM unless you choose otherwise.", nil
+	case reminderTargetChannel:
+		return fmt.Sprintf("Your reminders will be sent to <#%s> unless you choose otherwise.", channel), nil
+	}
+	return "Your reminders will be sent in the channel you set them in unless you choose otherwise.", nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sharesRole reports whether any of roleIDs is one of the reminder's roles
+func sharesRole(roles []string, roleIDs []string) bool {
+	for _, id := range roleIDs {
+		if containsString(roles, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDue reads when a reminder is due from text, either an absolute time read in loc or
+// an interval after from, returning the text with the time removed
+func parseDue(ctx context.Context, text string, from time.Time, loc *time.Location) (time.Time, string, error) {
+	due, remaining, ok, err := parseReminderTime(text, from, loc)
 	if err != nil {
-		span.AddField("storeReminder.error", err)
-		return err
+		return time.Time{}, text, err
+	}
+	if ok {
+		beeline.AddField(ctx, "parseDue.absolute", due)
+		return due, remaining, nil
 	}
 
-	if err = db.Disconnect(ctx); err != nil {
-		span.AddField("storeReminder.error", err)
-		return err
+	return parseInterval(ctx, text, from)
+}
+
+// durationPartPattern matches one part of a duration like 5h, 30 mins or 2 weeks. Units
+// are case insensitive apart from a bare M, which is rejected as it could mean minutes or
+// months.
+const durationPartPattern = `(\d+)\s*(years?|yrs?|y|months?|mons?|mo|weeks?|wks?|w|days?|d|hours?|hrs?|h|minutes?|mins?|m)`
+
+var (
+	durationPartRegexp = regexp.MustCompile(`(?i)` + durationPartPattern)
+	// durationPattern matches a whole duration, e.g. 1h30m, 2 hours 15 minutes or 1 week
+	// and 2 days
+	durationPattern = regexp.MustCompile(`(?i)\b` + durationPartPattern + `(?:\s*,?\s*(?:and\s+)?` + durationPartPattern + `)*\b`)
+)
+
+// reminderDuration is a length of time made up of calendar and clock parts, so "1 month"
+// lands on the same day of the next month
+type reminderDuration struct {
+	years, months, days int
+	clock               time.Duration
+}
+
+func (d reminderDuration) after(t time.Time) time.Time {
+	return t.AddDate(d.years, d.months, d.days).Add(d.clock)
+}
+
+func (d reminderDuration) isZero() bool {
+	return d == reminderDuration{}
+}
+
+func (d *reminderDuration) add(count int, unit string) error {
+	if unit == "M" {
+		return fmt.Errorf("%dM is ambiguous, use %dm or %d mins for minutes and %dmo or %d months for months", count, count, count, count, count)
 	}
 
+	switch strings.ToLower(unit) {
+	case "y", "yr", "yrs", "year", "years":
+		d.years += count
+	case "mo", "mon", "mons", "month", "months":
+		d.months += count
+	case "w", "wk", "wks", "week", "weeks":
+		d.days += 7 * count
+	case "d", "day", "days":
+		d.days += count
+	case "h", "hr", "hrs", "hour", "hours":
+		d.clock += time.Duration(count) * time.Hour
+	default:
+		d.clock += time.Duration(count) * time.Minute
+	}
 	return nil
 }
 
+// parseInterval finds a duration in text and returns the time that long after from, along
+// with the text with the duration removed. Text is expected to be like:
+// "do the thing 5h"
+// "1h30m wrankle the sprockets"
+// "stretch in 2 hours and 15 minutes"
+// supporting minutes, hours, days, weeks, months and years
+func parseInterval(ctx context.Context, text string, from time.Time) (time.Time, string, error) {
+	matches := durationPattern.FindAllStringIndex(text, -1)
+
+	beeline.AddField(ctx, "parseInterval.matcheslength", len(matches))
+
+	if len(matches) == 0 {
+		return time.Time{}, text, fmt.Errorf("No interval specified, try something like 30m, 1h30m, 2 days or 1 week")
+	}
+	if len(matches) > 1 {
+		first := text[matches[0][0]:matches[0][1]]
+		second := text[matches[1][0]:matches[1][1]]
+		return time.Time{}, text, fmt.Errorf("Found more than one time (%s and %s), put the parts of the time together like 1h30m", first, second)
+	}
+
+	duration := text[matches[0][0]:matches[0][1]]
+	beeline.AddField(ctx, "parseInterval.duration", duration)
+
+	var d reminderDuration
+	for _, part := range durationPartRegexp.FindAllStringSubmatch(duration, -1) {
+		count, err := strconv.Atoi(part[1])
+		if err != nil {
+			return time.Time{}, text, fmt.Errorf("%s is too big", part[1])
+		}
+		if err := d.add(count, part[2]); err != nil {
+			return time.Time{}, text, err
+		}
+	}
+
+	if d.isZero() {
+		return time.Time{}, text, fmt.Errorf("The reminder needs to be at least a minute away")
+	}
+
+	// drop the "in" from "stretch in 2 hours"
+	before := strings.TrimRight(text[:matches[0][0]], " ")
+	if lower := strings.ToLower(before); lower == "in" || strings.HasSuffix(lower, " in") {
+		before = before[:len(before)-2]
+	}
+	remaining := before + " " + text[matches[0][1]:]
+
+	return d.after(from), remaining, nil
+}
+
+// referencedText describes the message a reminder was set about, quoting the start of it
+// when it's known, with a link so the reminder still leads back to it when sent by DM
+func referencedText(ref *discordgo.Message, link string) string {
+	if ref == nil || strings.TrimSpace(ref.Content) == "" {
+		return link
+	}
+
+	quote := strings.TrimSpace(strings.SplitN(ref.Content, "\n", 2)[0])
+	if runes := []rune(quote); len(runes) > 100 {
+		quote = string(runes[:100]) + "..."
+	}
+	return fmt.Sprintf("\"%s\" %s", quote, link)
+}
+
+func storeReminder(ctx context.Context, store ReminderStore, r Reminder) (Reminder, error) {
+
+	ctx, span := beeline.StartSpan(ctx, "storeReminder")
+	defer span.Send()
+	span.AddField("storeReminder.due", r.Due)
+	span.AddField("storeReminder.creator", r.Creator)
+
+	r, err := store.Create(ctx, r)
+	if err != nil {
+		span.AddField("storeReminder.error", err)
+		return Reminder{}, err
+	}
+
+	span.AddField("storeReminder.id", r.ID)
+
+	return r, nil
+}
+
 func reminderHelp() string {
 	help := `RemindMe Help:
 	Will at creator near specified time with requested message.
-	Supports (m)inutes, (h/H)ours, (d/D)ays, or (M)onths
+	Supports (m)inutes, (h)ours, (d)ays, (w)eeks, (mo)nths and (y)ears,
+	combined or written out in full
 	
 	e.g. !remindme post memes 1h 
-
-	List all outstanding reminders using either:
+	!remindme post memes 1h30m
+	!remindme post memes in 2 days
+
+	Or an absolute time in your timezone, e.g.
+	!remindme at 5pm post memes
+	!remindme tomorrow 9am post memes
+	!remindme on %[1]d-12-24 18:00 post memes
+	!remindme next friday post memes
+
+	Remind other people or roles by mentioning them first, e.g.
+	!remindme @alice @team review the PR tomorrow 10am
+	Reminding roles needs the reminder-roles flag or the Mention Everyone permission.
+	!remindme optout
+	stops other people setting reminders for you, !remindme optin allows them again
+
+	Choose where a reminder is sent by starting with dm, here or a channel, e.g.
+	!remindme dm stretch 1h
+	!remindme #standup standup every weekday at 09:30
+	!remindme default dm
+	sets where your reminders go when you don't choose. You can also DM the bot
+	to set reminders.
+
+	Repeat a reminder with every, e.g.
+	!remindme every weekday at 09:30 standup
+	!remindme every 2 weeks pay rent
+	!remindme every month on the 1st until %[1]d-12-31 check the smoke alarms
+
+	Times are in the timezone you registered with !time set, or UTC if you haven't.
+
+	Cancel or change a reminder using the ID shown when it's created or listed:
+	!remindme cancel <id>
+	for a repeating reminder this cancels every occurrence, use
+	!remindme cancel <id> next
+	to skip just the next one
+	!remindme edit <id> <new text and/or time>
+	Delivered reminders have buttons to snooze them for 10m, 1h or until tomorrow.
+
+	Reply to a message with !remindme <time> for a reminder about that message, or react
+	to it with ⏰ and pick a time in the DM that follows.
+
+	!remindme export
+	DMs you your reminders as a calendar file
+	!remindme import
+	with an .ics file attached adds a reminder for each event in it
+
+	List outstanding reminders, soonest first, using one of:
 	!remindme list
-	for all remidners created by the user on the server
+	for reminders the user created or is reminded by on the server
+	!remindme list mine
+	the same as !remindme list
+	!remindme list @user
+	for reminders another user created or is reminded by
+	!remindme list this-channel
+	for reminders set in or sent to the current channel
 	!remindme list all
 	for all reminders created on the server by all users
+	In a DM !remindme list shows the user's reminders from every server.
 	`
 
-	return help
+	// date examples are for next year so they're never in the past
+	return fmt.Sprintf(help, time.Now().Year()+1)
 }
 
-func listReminders(ctx context.Context, session *discordgo.Session, message *discordgo.Message) (string, error) {
+// maxListLength keeps a reminder list inside Discord's 2000 character message limit
+const maxListLength = 1900
+
+var userMentionPattern = regexp.MustCompile(`^<@!?(\d+)>$`)
+
+// listReminders lists upcoming reminders, soonest first. By default it shows the author's
+// own reminders, those they created or are a recipient of, and can instead show "all" on
+// the server, those in "this-channel" or those involving a mentioned user. In a DM it
+// shows every reminder the author created.
+func listReminders(ctx context.Context, store ReminderStore, session *discordgo.Session, message *discordgo.Message) (string, error) {
 	ctx, span := beeline.StartSpan(ctx, "listReminders")
 	defer span.Send()
 
-	start := time.Now().UnixNano() / 1000000
+	filter := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(message.Content, "list")))
+	now := time.Now()
 
-	var query bson.M
-	if message.Content == "list all" {
+	var res []Reminder
+	var err error
+	var keep func(r Reminder) bool
+
+	switch {
+	case message.GuildID == "":
+		span.AddField("listReminders.type", "dm")
+		res, err = store.ListByCreator(ctx, message.Author.ID, now)
+	case filter == "all":
 		span.AddField("listReminders.type", "all")
-		query = bson.M{
-			"due": bson.M{
-				"$gt": start,
-			},
-			"server": bson.M{
-				"$eq": message.GuildID,
-			},
-			"botsource": bson.M{
-				"$eq": "GoDiscordBot",
-			},
+		res, err = store.ListByGuild(ctx, message.GuildID, now)
+	case filter == "this-channel":
+		span.AddField("listReminders.type", "channel")
+		res, err = store.ListByGuild(ctx, message.GuildID, now)
+		keep = func(r Reminder) bool {
+			return r.Channel == message.ChannelID || r.TargetChannel == message.ChannelID
+		}
+	case filter == "" || filter == "mine" || userMentionPattern.MatchString(filter):
+		user := message.Author.ID
+		if m := userMentionPattern.FindStringSubmatch(filter); m != nil {
+			user = m[1]
 		}
-	} else {
 		span.AddField("listReminders.type", "singleUser")
-		query = bson.M{
-			"due": bson.M{
-				"$gt": start,
-			},
-			"server": bson.M{
-				"$eq": message.GuildID,
-			},
-			"creator": bson.M{
-				"$eq": message.Author.ID,
-			},
-			"botsource": bson.M{
-				"$eq": "GoDiscordBot",
-			},
+		res, err = store.ListByGuild(ctx, message.GuildID, now)
+		keep = func(r Reminder) bool {
+			return r.Creator == user || containsString(r.Recipients, user)
 		}
+	default:
+		return "", fmt.Errorf("Unknown list filter %s, try list, list mine, list all, list this-channel or list @user", filter)
 	}
 
-	span.AddField("listReminders.query", query)
-
-	db, err := connectDb(ctx, os.Getenv("COSMOSDB_URI"))
-	if err != nil {
-		span.AddField("listReminders.connect.error", err)
-		return "", err
-	}
-
-	res, err := runQuery(ctx, db, query)
 	if err != nil {
 		span.AddField("listReminders.error", err)
 		return "", err
 	}
 
-	if len(res) == 0 {
-		return "No remaining remidners", nil
-	}
-
-	var response strings.Builder
-	count := 0
-	for _, item := range res {
-		var r Reminder
-
-		temp, err := bson.Marshal(item)
-		if err != nil {
-			span.AddField("listReminders.error", err)
-			return "", err
-		}
-
-		err = bson.Unmarshal(temp, &r)
-		if err != nil {
-			span.AddField("listReminders.error", err)
-			return "", err
+	var reminders []Reminder
+	for _, r := range res {
+		if keep == nil || keep(r) {
+			reminders = append(reminders, r)
 		}
+	}
+	sort.SliceStable(reminders, func(i, j int) bool { return reminders[i].Due.Before(reminders[j].Due) })
 
-		author, err := session.GuildMember(r.Server, r.Creator)
-		if err != nil {
-			span.AddField("listReminders.error", err)
-			return "", err
-		}
-		count++
+	span.AddField("listReminders.count", len(reminders))
 
-		r.Message = replaceMentionedUser(ctx, session, r.Message, r.Server)
+	if len(reminders) == 0 {
+		return "No remaining reminders", nil
+	}
 
-		// from: due: message:
-		var text string
-		if author.Nick != "" {
-			text = fmt.Sprintf("From: %v Due: %v Message: %v", author.Nick, r.Due, r.Message)
-		} else {
-			text = fmt.Sprintf("From: %v Due: %v Message: %v", author.User.Username, r.Due, r.Message)
+	var response strings.Builder
+	for i, r := range reminders {
+		line := formatReminder(ctx, session, r, message.GuildID == "")
+		if response.Len()+len(line) > maxListLength {
+			fmt.Fprintf(&response, "...and %d more", len(reminders)-i)
+			break
 		}
-		response.WriteString(text)
+		response.WriteString(line)
 		response.WriteString("\n")
 	}
-	span.AddField("listReminders.count", count)
 
 	return response.String(), nil
 }
 
-func replaceMentionedUser(ctx context.Context, session *discordgo.Session, message string, server string) string {
-	ctx, span := beeline.StartSpan(ctx, "replaceMentionedUser")
-	defer span.Send()
+// formatReminder describes a reminder on one line with its ID, a relative due time that
+// Discord shows in the reader's timezone, who it's from and for, and a link back to the
+// message that created it
+func formatReminder(ctx context.Context, session *discordgo.Session, r Reminder, own bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "`%s` <t:%d:R> %s", r.ID, r.Due.Unix(), strings.TrimSpace(replaceMentions(ctx, session, r.Message, r.Server)))
 
-	mentionPattern := regexp.MustCompile("<@!?(?P<id>\\d+)>")
-	names := mentionPattern.SubexpNames()
-	elements := map[string]string{}
+	var details []string
+	if !own {
+		details = append(details, "from "+userName(ctx, session, r.Server, r.Creator))
+	}
 
-	matchingStrings := mentionPattern.FindAllStringSubmatch(message, -1)
-	matches := []string{}
+	var to []string
+	for _, user := range r.Recipients {
+		to = append(to, "@"+userName(ctx, session, r.Server, user))
+	}
+	for _, role := range r.Roles {
+		to = append(to, replaceMentions(ctx, session, "<@&"+role+">", r.Server))
+	}
+	if len(to) > 0 {
+		details = append(details, "for "+strings.Join(to, ", "))
+	}
 
-	span.AddField("replaceMentionedUser.matcheslength", len(matchingStrings))
-	span.AddField("replaceMentionedUser.matches", matchingStrings)
+	switch r.Target {
+	case reminderTargetDM:
+		details = append(details, "by DM")
+	case reminderTargetChannel:
+		details = append(details, "in "+replaceMentions(ctx, session, "<#"+r.TargetChannel+">", r.Server))
+	}
 
-	if len(matchingStrings) == 0 {
-		return message
+	if r.Recurrence != nil {
+		details = append(details, "repeats "+r.Recurrence.String())
 	}
 
-	matches = matchingStrings[0]
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+	}
 
-	for i, match := range matches {
-		elements[names[i]] = match
+	if r.SourceMessage != "" {
+		fmt.Fprintf(&b, " <%s>", messageLink(r.Server, r.Channel, r.SourceMessage))
 	}
 
-	span.AddField("replaceMentionedUser.id", elements["id"])
+	return b.String()
+}
 
-	user, err := session.GuildMember(server, elements["id"])
-	if err != nil {
-		span.AddField("replaceMentionedUser.error", err)
-		return message
+// userName is the user's display name on the server, or their username outside a server
+func userName(ctx context.Context, session *discordgo.Session, server string, userID string) string {
+	if server != "" {
+		if member, err := guildMember(ctx, session, server, userID); err == nil {
+			return memberDisplayName(member)
+		}
+	} else if user, err := session.User(userID); err == nil {
+		return user.Username
 	}
+	return userID
+}
 
-	span.AddField("replaceMentionedUser.nick", user.Nick)
+var mentionPattern = regexp.MustCompile(`<(@!?|@&|#)(\d+)>`)
 
-	if strings.Contains(message, "<@!") {
-		if user.Nick != "" {
-			message = strings.Replace(message, ("<@!" + elements["id"] + ">"), user.Nick, 1)
-			span.AddField("replaceMentionedUser.user", user.Nick)
-		} else {
-			message = strings.Replace(message, ("<@!" + elements["id"] + ">"), user.User.Username, 1)
-			span.AddField("replaceMentionedUser.user", user.User.Username)
-		}
-	} else if strings.Contains(message, "<@") {
-		if user.Nick != "" {
-			message = strings.Replace(message, ("<@" + elements["id"] + ">"), user.Nick, 1)
-			span.AddField("replaceMentionedUser.user", user.Nick)
-		} else {
-			message = strings.Replace(message, ("<@" + elements["id"] + ">"), user.User.Username, 1)
-			span.AddField("replaceMentionedUser.user", user.User.Username)
+// replaceMentions swaps user, role and channel mentions for their names, so a list of
+// reminders doesn't ping anyone. Mentions that can't be resolved are left alone.
+func replaceMentions(ctx context.Context, session *discordgo.Session, message string, server string) string {
+	ctx, span := beeline.StartSpan(ctx, "replaceMentions")
+	defer span.Send()
+
+	var roles []*discordgo.Role
+	count := 0
+
+	message = mentionPattern.ReplaceAllStringFunc(message, func(mention string) string {
+		m := mentionPattern.FindStringSubmatch(mention)
+		kind, id := m[1], m[2]
+		count++
+
+		switch kind {
+		case "@&":
+			if roles == nil && server != "" {
+				var err error
+				if roles, err = guildRoles(ctx, session, server); err != nil {
+					span.AddField("replaceMentions.error", err)
+				}
+			}
+			for _, role := range roles {
+				if role.ID == id {
+					return "@" + role.Name
+				}
+			}
+		case "#":
+			if channel, err := guildChannel(ctx, session, id); err == nil {
+				return "#" + channel.Name
+			}
+		default:
+			if name := userName(ctx, session, server, id); name != id {
+				return "@" + name
+			}
 		}
-	}
+		return mention
+	})
+
+	span.AddField("replaceMentions.count", count)
+
 	return message
 }
@@ -1 +1,574 @@
 package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+func testReminderMessage(content string, sent time.Time) *discordgo.Message {
+	return &discordgo.Message{
+		ID:        "3001",
+		ChannelID: "2001",
+		GuildID:   "1001",
+		Content:   content,
+		Timestamp: sent,
+		Author:    &discordgo.User{ID: "4001", Username: "chris"},
+	}
+}
+
+func TestCreateReminderMemoryStore(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	sent := time.Now().Truncate(time.Millisecond)
+
+	resp, err := createReminder(ctx, store, nil, testReminderMessage("post memes 1h", sent), reminderOptions{})
+	if err != nil {
+		t.Fatalf("createReminder: FAILED, unexpected error %v", err)
+	}
+	reminders, err := store.ListByUser(ctx, "1001", "4001", sent)
+	if err != nil {
+		t.Fatalf("ListByUser: FAILED, unexpected error %v", err)
+	}
+	if len(reminders) != 1 {
+		t.Fatalf("ListByUser: FAILED, expected 1 reminder but got %d", len(reminders))
+	}
+
+	r := reminders[0]
+	if r.ID == "" {
+		t.Errorf("createReminder: FAILED, expected an ID to be assigned")
+	}
+	if want := fmt.Sprintf("Reminder %s added for <t:%d:F>.", r.ID, sent.Add(time.Hour).Unix()); resp != want {
+		t.Errorf("createReminder: FAILED, expected %v but got %v", want, resp)
+	}
+	if !r.Due.Equal(sent.Add(time.Hour)) {
+		t.Errorf("createReminder: FAILED, expected due %v but got %v", sent.Add(time.Hour), r.Due)
+	}
+
+	if _, err := createReminder(ctx, store, nil, testReminderMessage("post memes", sent), reminderOptions{}); err == nil {
+		t.Errorf("createReminder without an interval: FAILED, expected an error")
+	}
+}
+
+func TestMemoryReminderStoreDue(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	now := time.Now()
+
+	soon, _ := store.Create(ctx, Reminder{Due: now.Add(2 * time.Minute), Server: "1001", Creator: "4001", BotSource: reminderBotSource})
+	store.Create(ctx, Reminder{Due: now.Add(time.Hour), Server: "1001", Creator: "4002", BotSource: reminderBotSource})
+	store.Create(ctx, Reminder{Due: now.Add(3 * time.Minute), Server: "1001", Creator: "4001", BotSource: "SomethingElse"})
+
+	due, _ := store.Due(ctx, now, now.Add(5*time.Minute))
+	if len(due) != 1 || due[0].ID != soon.ID {
+		t.Fatalf("Due: FAILED, expected only %v but got %v", soon.ID, due)
+	}
+
+	if err := store.MarkDelivered(ctx, soon.ID); err != nil {
+		t.Fatalf("MarkDelivered: FAILED, unexpected error %v", err)
+	}
+
+	if due, _ := store.Due(ctx, now, now.Add(5*time.Minute)); len(due) != 0 {
+		t.Errorf("Due after delivery: FAILED, expected no reminders but got %v", due)
+	}
+
+	if all, _ := store.ListByGuild(ctx, "1001", now); len(all) != 2 {
+		t.Errorf("ListByGuild: FAILED, expected 2 reminders but got %d", len(all))
+	}
+
+	if err := store.Delete(ctx, soon.ID); err != nil {
+		t.Fatalf("Delete: FAILED, unexpected error %v", err)
+	}
+
+	if all, _ := store.ListByGuild(ctx, "1001", now); len(all) != 1 {
+		t.Errorf("ListByGuild after delete: FAILED, expected 1 reminder but got %d", len(all))
+	}
+}
+
+func TestFileReminderStorePersists(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "reminders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("REMINDER_STORE", "file")
+	os.Setenv("REMINDER_STORE_PATH", filepath.Join(dir, "reminders.json"))
+	defer os.Unsetenv("REMINDER_STORE")
+	defer os.Unsetenv("REMINDER_STORE_PATH")
+
+	ctx := context.Background()
+	now := time.Now()
+
+	db, err := openDatabase(ctx)
+	if err != nil {
+		t.Fatalf("openDatabase: FAILED, unexpected error %v", err)
+	}
+	store := newReminderStore(db)
+
+	r, err := store.Create(ctx, Reminder{Due: now.Add(time.Minute), Server: "1001", Creator: "4001", Message: "stretch", BotSource: reminderBotSource})
+	if err != nil {
+		t.Fatalf("Create: FAILED, unexpected error %v", err)
+	}
+	store.MarkDelivered(ctx, r.ID)
+
+	db, err = openDatabase(ctx)
+	if err != nil {
+		t.Fatalf("openDatabase: FAILED, unexpected error %v", err)
+	}
+	reopened := newReminderStore(db)
+
+	reminders, _ := reopened.ListByUser(ctx, "1001", "4001", now)
+	if len(reminders) != 1 || reminders[0].Message != "stretch" || reminders[0].Status != reminderDelivered {
+		t.Errorf("reopened store: FAILED, expected the delivered reminder but got %v", reminders)
+	}
+}
+
+func TestCancelAndEditReminder(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	sent := time.Now().Truncate(time.Millisecond)
+
+	mine, _ := store.Create(ctx, Reminder{Due: sent.Add(time.Hour), Server: "1001", Creator: "4001", Message: "post memes", BotSource: reminderBotSource})
+	theirs, _ := store.Create(ctx, Reminder{Due: sent.Add(time.Hour), Server: "1001", Creator: "4002", Message: "water plants", BotSource: reminderBotSource})
+
+	if _, err := cancelReminder(ctx, store, nil, testReminderMessage("cancel "+theirs.ID, sent)); err == nil {
+		t.Errorf("cancelReminder of another user's reminder: FAILED, expected an error")
+	}
+
+	if _, err := editReminder(ctx, store, nil, testReminderMessage("edit "+mine.ID+" 2h", sent), reminderOptions{}); err != nil {
+		t.Fatalf("editReminder time: FAILED, unexpected error %v", err)
+	}
+	if r, _ := store.Get(ctx, mine.ID); !r.Due.Equal(sent.Add(2*time.Hour)) || r.Message != "post memes" {
+		t.Errorf("editReminder time: FAILED, expected due %v with the same text but got %v %q", sent.Add(2*time.Hour), r.Due, r.Message)
+	}
+
+	if _, err := editReminder(ctx, store, nil, testReminderMessage("edit "+mine.ID+" post better memes", sent), reminderOptions{}); err != nil {
+		t.Fatalf("editReminder text: FAILED, unexpected error %v", err)
+	}
+	if r, _ := store.Get(ctx, mine.ID); !r.Due.Equal(sent.Add(2*time.Hour)) || r.Message != "post better memes" {
+		t.Errorf("editReminder text: FAILED, expected the new text with the same due time but got %v %q", r.Due, r.Message)
+	}
+
+	if _, err := cancelReminder(ctx, store, nil, testReminderMessage("cancel "+mine.ID, sent)); err != nil {
+		t.Fatalf("cancelReminder: FAILED, unexpected error %v", err)
+	}
+	if _, err := store.Get(ctx, mine.ID); !errors.Is(err, gosmosdb.ErrNotFound) {
+		t.Errorf("cancelReminder: FAILED, expected the reminder to be deleted but got %v", err)
+	}
+}
+
+func TestSnoozeReminder(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	now := time.Now().Truncate(time.Millisecond)
+
+	r, _ := store.Create(ctx, Reminder{Due: now.Add(-time.Minute), Server: "1001", Creator: "4001", BotSource: reminderBotSource})
+	store.MarkDelivered(ctx, r.ID)
+
+	if _, err := snoozeReminder(ctx, store, nil, r.ID, "4002", []string{"5001"}, "10m", now, reminderLimits{}); err != nil {
+		t.Fatalf("snoozeReminder by someone else: FAILED, unexpected error %v", err)
+	}
+	if got, _ := store.Get(ctx, r.ID); got.Status != reminderDelivered {
+		t.Errorf("snoozeReminder by someone else: FAILED, expected the reminder to be left alone but got %v", got.Status)
+	}
+	if mine, _ := store.ListByCreator(ctx, "4002", time.Time{}); len(mine) != 0 {
+		t.Errorf("snoozeReminder by someone else: FAILED, expected no copy but got %v", mine)
+	}
+
+	// a member of a role the reminder was for snoozes a copy that's theirs, not the creator's
+	team, _ := store.Create(ctx, Reminder{Due: now.Add(-time.Minute), Server: "1001", Creator: "4001", Roles: []string{"5001"}, BotSource: reminderBotSource})
+	store.MarkDelivered(ctx, team.ID)
+	if _, err := snoozeReminder(ctx, store, nil, team.ID, "4002", []string{"5002", "5001"}, "10m", now, reminderLimits{}); err != nil {
+		t.Fatalf("snoozeReminder by a role member: FAILED, unexpected error %v", err)
+	}
+	copies, _ := store.ListByCreator(ctx, "4002", time.Time{})
+	if len(copies) != 1 || !copies[0].Due.Equal(now.Add(10*time.Minute)) || strings.Join(copies[0].Recipients, ",") != "4002" || len(copies[0].Roles) != 0 {
+		t.Errorf("snoozeReminder by a role member: FAILED, expected a copy for 4002 alone but got %+v", copies)
+	}
+	if got, _ := store.Get(ctx, team.ID); got.Status != reminderDelivered {
+		t.Errorf("snoozeReminder by a role member: FAILED, expected the original to be left alone but got %v", got.Status)
+	}
+
+	tests := map[string]time.Time{
+		"10m":      now.Add(10 * time.Minute),
+		"1h":       now.Add(time.Hour),
+		"tomorrow": now.AddDate(0, 0, 1),
+	}
+
+	for value, want := range tests {
+		if _, err := snoozeReminder(ctx, store, nil, r.ID, "4001", nil, value, now, reminderLimits{}); err != nil {
+			t.Fatalf("snoozeReminder %v: FAILED, unexpected error %v", value, err)
+		}
+		got, _ := store.Get(ctx, r.ID)
+		if !got.Due.Equal(want) || got.Status != reminderPending {
+			t.Errorf("snoozeReminder %v: FAILED, expected pending at %v but got %v at %v", value, want, got.Status, got.Due)
+		}
+	}
+}
+
+func TestParseReminderTime(t *testing.T) {
+
+	london, _ := time.LoadLocation("Europe/London")
+	// Friday 3rd June 2022, 10:00 in London
+	from := time.Date(2022, 6, 3, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		text    string
+		message string
+		due     time.Time
+	}{
+		{"at 5pm post memes", "post memes", time.Date(2022, 6, 3, 17, 0, 0, 0, london)},
+		{"post memes at 9:30am", "post memes", time.Date(2022, 6, 4, 9, 30, 0, 0, london)},
+		{"tomorrow 9am post memes", "post memes", time.Date(2022, 6, 4, 9, 0, 0, 0, london)},
+		{"tomorrow at 18:15 post memes", "post memes", time.Date(2022, 6, 4, 18, 15, 0, 0, london)},
+		{"post memes tonight", "post memes", time.Date(2022, 6, 3, 20, 0, 0, 0, london)},
+		{"on 2026-12-24 18:00 wrap presents", "wrap presents", time.Date(2026, 12, 24, 18, 0, 0, 0, london)},
+		{"2026-12-24 wrap presents", "wrap presents", time.Date(2026, 12, 24, 9, 0, 0, 0, london)},
+		{"next friday post memes", "post memes", time.Date(2022, 6, 10, 9, 0, 0, 0, london)},
+		{"on monday at 12pm lunch", "lunch", time.Date(2022, 6, 6, 12, 0, 0, 0, london)},
+		{"friday at 5pm post memes", "post memes", time.Date(2022, 6, 3, 17, 0, 0, 0, london)},
+		{"friday at 9am post memes", "post memes", time.Date(2022, 6, 10, 9, 0, 0, 0, london)},
+		{"post memes monday 9:30am", "post memes", time.Date(2022, 6, 6, 9, 30, 0, 0, london)},
+	}
+
+	for _, tc := range tests {
+		due, text, ok, err := parseReminderTime(tc.text, from, london)
+		if err != nil || !ok {
+			t.Errorf("parseReminderTime %q: FAILED, unexpected error %v", tc.text, err)
+			continue
+		}
+		if !due.Equal(tc.due) {
+			t.Errorf("parseReminderTime %q: FAILED, expected %v but got %v", tc.text, tc.due, due)
+		}
+		if got := strings.Join(strings.Fields(text), " "); got != tc.message {
+			t.Errorf("parseReminderTime %q: FAILED, expected message %q but got %q", tc.text, tc.message, got)
+		}
+	}
+
+	for _, text := range []string{"today at 8am too late", "on 2021-01-01 too late", "at 13pm nonsense", "friday post memes at 5pm"} {
+		if _, _, _, err := parseReminderTime(text, from, london); err == nil {
+			t.Errorf("parseReminderTime %q: FAILED, expected an error", text)
+		}
+	}
+
+	for _, text := range []string{"post memes 1h", "submit timesheet today 2h", "ask about next friday's party in 3 days", "call about friday 2h"} {
+		if _, _, ok, _ := parseReminderTime(text, from, london); ok {
+			t.Errorf("parseReminderTime %q: FAILED, expected no absolute time", text)
+		}
+	}
+
+	// a day in the middle of the message is part of it, so the interval is used
+	due, text, err := parseDue(context.Background(), "submit timesheet today 2h", from, london)
+	if err != nil {
+		t.Fatalf("parseDue: FAILED, unexpected error %v", err)
+	}
+	if !due.Equal(from.Add(2 * time.Hour)) {
+		t.Errorf("parseDue: FAILED, expected %v but got %v", from.Add(2*time.Hour), due)
+	}
+	if got := strings.Join(strings.Fields(text), " "); got != "submit timesheet today" {
+		t.Errorf("parseDue: FAILED, expected message %q but got %q", "submit timesheet today", got)
+	}
+}
+
+type TestDataDuration struct {
+	input    string
+	message  string
+	due      time.Time
+	hasError bool
+}
+
+func TestParseInterval(t *testing.T) {
+
+	from := time.Date(2022, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	dataItems := []TestDataDuration{
+		{"post memes 5m", "post memes", from.Add(5 * time.Minute), false},
+		{"5h post memes", "post memes", from.Add(5 * time.Hour), false},
+		{"post memes 3D", "post memes", from.AddDate(0, 0, 3), false},
+		{"post memes 1h30m", "post memes", from.Add(90 * time.Minute), false},
+		{"post memes 1d 2h", "post memes", from.AddDate(0, 0, 1).Add(2 * time.Hour), false},
+		{"post memes in 2 hours", "post memes", from.Add(2 * time.Hour), false},
+		{"post memes in 2 hours and 15 minutes", "post memes", from.Add(135 * time.Minute), false},
+		{"post memes in 45 mins", "post memes", from.Add(45 * time.Minute), false},
+		{"post memes 1 week", "post memes", from.AddDate(0, 0, 7), false},
+		{"post memes 2w", "post memes", from.AddDate(0, 0, 14), false},
+		{"pay rent 1 month", "pay rent", from.AddDate(0, 1, 0), false},
+		{"pay rent 1mo", "pay rent", from.AddDate(0, 1, 0), false},
+		{"renew domain 1y", "renew domain", from.AddDate(1, 0, 0), false},
+		{"renew domain in 2 years", "renew domain", from.AddDate(2, 0, 0), false},
+		{"eat 5 mangoes 1h", "eat 5 mangoes", from.Add(time.Hour), false},
+		{"post memes 3M", "", time.Time{}, true},
+		{"post memes 1h30M", "", time.Time{}, true},
+		{"post memes 1h then 2h", "", time.Time{}, true},
+		{"post memes 0m", "", time.Time{}, true},
+		{"post memes", "", time.Time{}, true},
+		{"post memes 5x", "", time.Time{}, true},
+	}
+
+	for _, item := range dataItems {
+		due, text, err := parseInterval(context.Background(), item.input, from)
+
+		if item.hasError {
+			if err == nil {
+				t.Errorf("parseInterval with args %v: FAILED, expected an error but got %v", item.input, due)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseInterval with args %v: FAILED, unexpected error %v", item.input, err)
+			continue
+		}
+		if !due.Equal(item.due) {
+			t.Errorf("parseInterval with args %v: FAILED, expected %v but got %v", item.input, item.due, due)
+		}
+		if got := strings.Join(strings.Fields(text), " "); got != item.message {
+			t.Errorf("parseInterval with args %v: FAILED, expected message %q but got %q", item.input, item.message, got)
+		}
+	}
+}
+
+func TestCreateReminderForOthers(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+	sent := time.Now().Truncate(time.Millisecond)
+
+	if _, err := setReminderOptOut(ctx, settings, "4003", true); err != nil {
+		t.Fatalf("setReminderOptOut: FAILED, unexpected error %v", err)
+	}
+
+	opts := reminderOptions{settings: settings}
+	if _, err := createReminder(ctx, store, nil, testReminderMessage("<@4002> <@&5001> review the PR 1h", sent), opts); err == nil {
+		t.Errorf("createReminder for a role without permission: FAILED, expected an error")
+	}
+
+	resp, err := createReminder(ctx, store, nil, testReminderMessage("<@4002> <@!4003> review the PR with <@4004> 1h", sent), opts)
+	if err != nil {
+		t.Fatalf("createReminder for others: FAILED, unexpected error %v", err)
+	}
+	if !strings.Contains(resp, "<@4003> opted out") {
+		t.Errorf("createReminder for others: FAILED, expected to be told 4003 opted out but got %v", resp)
+	}
+
+	reminders, _ := store.ListByUser(ctx, "1001", "4001", sent)
+	if len(reminders) != 1 {
+		t.Fatalf("createReminder for others: FAILED, expected 1 reminder but got %d", len(reminders))
+	}
+	r := reminders[0]
+	if len(r.Recipients) != 1 || r.Recipients[0] != "4002" || r.Creator != "4001" {
+		t.Errorf("createReminder for others: FAILED, expected recipient 4002 from 4001 but got %v from %v", r.Recipients, r.Creator)
+	}
+	if !strings.Contains(r.Message, "<@4004>") {
+		t.Errorf("createReminder for others: FAILED, expected mentions in the text to be kept but got %q", r.Message)
+	}
+
+	opts.canMentionRoles = true
+	if _, err := createReminder(ctx, store, nil, testReminderMessage("<@&5001> standup 1h", sent), opts); err != nil {
+		t.Errorf("createReminder for a role with permission: FAILED, unexpected error %v", err)
+	}
+
+	if _, err := createReminder(ctx, store, nil, testReminderMessage("<@4003> review the PR 1h", sent), opts); err == nil {
+		t.Errorf("createReminder only for someone who opted out: FAILED, expected an error")
+	}
+}
+
+func TestReminderMessage(t *testing.T) {
+
+	r := Reminder{Creator: "4001", Message: "review the PR", Roles: []string{"5001"}}
+
+	m := reminderMessage(r, []string{"4002"})
+	if want := "Hey <@4002> <@&5001>, <@4001> asked me to remind you: review the PR"; m.Content != want {
+		t.Errorf("reminderMessage: FAILED, expected %v but got %v", want, m.Content)
+	}
+	if len(m.AllowedMentions.Users) != 1 || len(m.AllowedMentions.Roles) != 1 {
+		t.Errorf("reminderMessage: FAILED, expected to ping only the recipient and role but got %+v", m.AllowedMentions)
+	}
+
+	m = reminderMessage(Reminder{Creator: "4001", Message: "stretch"}, nil)
+	if want := "Hey <@4001>, remember stretch"; m.Content != want {
+		t.Errorf("reminderMessage for the creator: FAILED, expected %v but got %v", want, m.Content)
+	}
+}
+
+func TestCreateReminderTarget(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+	sent := time.Now().Truncate(time.Millisecond)
+	opts := reminderOptions{
+		settings:        settings,
+		canMentionRoles: true,
+		canPostIn:       func(channelID string) bool { return channelID == "2002" },
+	}
+
+	tests := []struct {
+		content string
+		target  string
+		channel string
+	}{
+		{"dm stretch 1h", reminderTargetDM, ""},
+		{"<#2002> standup 1h", reminderTargetChannel, "2002"},
+		{"here stretch 1h", reminderTargetSource, ""},
+		{"stretch 1h", "", ""},
+	}
+
+	for _, tc := range tests {
+		store = newMemoryReminderStore()
+		if _, err := createReminder(ctx, store, nil, testReminderMessage(tc.content, sent), opts); err != nil {
+			t.Errorf("createReminder %q: FAILED, unexpected error %v", tc.content, err)
+			continue
+		}
+		reminders, _ := store.ListByUser(ctx, "1001", "4001", sent)
+		if len(reminders) != 1 || reminders[0].Target != tc.target || reminders[0].TargetChannel != tc.channel {
+			t.Errorf("createReminder %q: FAILED, expected target %q %q but got %v", tc.content, tc.target, tc.channel, reminders)
+		}
+	}
+
+	for _, content := range []string{"<#2003> standup 1h", "dm <@&5001> standup 1h"} {
+		if _, err := createReminder(ctx, store, nil, testReminderMessage(content, sent), opts); err == nil {
+			t.Errorf("createReminder %q: FAILED, expected an error", content)
+		}
+	}
+
+	if _, err := setReminderDefault(ctx, settings, testReminderMessage("default dm", sent), opts); err != nil {
+		t.Fatalf("setReminderDefault: FAILED, unexpected error %v", err)
+	}
+
+	// created in a DM with the bot, so there's no guild
+	store = newMemoryReminderStore()
+	message := testReminderMessage("stretch 1h", sent)
+	message.GuildID = ""
+	if _, err := createReminder(ctx, store, nil, message, opts); err != nil {
+		t.Fatalf("createReminder in a DM: FAILED, unexpected error %v", err)
+	}
+	reminders, _ := store.ListByUser(ctx, "", "4001", sent)
+	if len(reminders) != 1 || reminders[0].Target != reminderTargetDM {
+		t.Errorf("createReminder with a default: FAILED, expected a DM reminder but got %v", reminders)
+	}
+}
+
+func TestListReminders(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	now := time.Now()
+
+	state := discordgo.NewState()
+	err := state.GuildAdd(&discordgo.Guild{
+		ID:    "1001",
+		Roles: []*discordgo.Role{{ID: "5001", Name: "raiders"}},
+		Members: []*discordgo.Member{
+			{GuildID: "1001", User: &discordgo.User{ID: "4001", Username: "chris"}},
+			{GuildID: "1001", User: &discordgo.User{ID: "4002", Username: "sam"}},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: "2001", GuildID: "1001", Name: "general"},
+			{ID: "2002", GuildID: "1001", Name: "raids"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GuildAdd: %v", err)
+	}
+	s := &discordgo.Session{State: state}
+
+	reminders := []Reminder{
+		{ID: "later", Due: now.Add(2 * time.Hour), Message: "stretch", Server: "1001", Creator: "4001", Channel: "2001", SourceMessage: "3001", BotSource: reminderBotSource},
+		{ID: "soon", Due: now.Add(time.Hour), Message: "raid in <#2002>", Server: "1001", Creator: "4002", Roles: []string{"5001"}, Channel: "2002", SourceMessage: "3002", BotSource: reminderBotSource},
+		{ID: "forme", Due: now.Add(3 * time.Hour), Message: "water", Server: "1001", Creator: "4002", Recipients: []string{"4001"}, Channel: "2002", BotSource: reminderBotSource},
+	}
+	for _, r := range reminders {
+		if _, err := store.Create(ctx, r); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	tests := []struct {
+		content string
+		ids     []string
+	}{
+		{"list", []string{"later", "forme"}},
+		{"list mine", []string{"later", "forme"}},
+		{"list all", []string{"soon", "later", "forme"}},
+		{"list this-channel", []string{"later"}},
+		{"list <@4002>", []string{"soon", "forme"}},
+	}
+
+	for _, tc := range tests {
+		resp, err := listReminders(ctx, store, s, testReminderMessage(tc.content, now))
+		if err != nil {
+			t.Errorf("listReminders %q: FAILED, unexpected error %v", tc.content, err)
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(resp), "\n")
+		var ids []string
+		for _, line := range lines {
+			ids = append(ids, strings.Trim(strings.Fields(line)[0], "`"))
+		}
+		if strings.Join(ids, ",") != strings.Join(tc.ids, ",") {
+			t.Errorf("listReminders %q: FAILED, expected %v but got %v", tc.content, tc.ids, ids)
+		}
+	}
+
+	resp, _ := listReminders(ctx, store, s, testReminderMessage("list all", now))
+	expected := fmt.Sprintf("`soon` <t:%d:R> raid in #raids (from sam, for @raiders) <https://discord.com/channels/1001/2002/3002>", now.Add(time.Hour).Unix())
+	if first := strings.Split(resp, "\n")[0]; first != expected {
+		t.Errorf("listReminders: FAILED, expected %v but got %v", expected, first)
+	}
+
+	if _, err := listReminders(ctx, store, s, testReminderMessage("list everything", now)); err == nil {
+		t.Errorf("listReminders with an unknown filter: FAILED, expected an error")
+	}
+}
+
+func TestCreateReminderFromReply(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	sent := time.Now().Truncate(time.Millisecond)
+
+	message := testReminderMessage("2h", sent)
+	message.MessageReference = &discordgo.MessageReference{MessageID: "3000", ChannelID: "2001", GuildID: "1001"}
+	message.ReferencedMessage = &discordgo.Message{ID: "3000", Content: "raid signups close friday\nreact below"}
+
+	if _, err := createReminder(ctx, store, nil, message, reminderOptions{}); err != nil {
+		t.Fatalf("createReminder: FAILED, unexpected error %v", err)
+	}
+
+	reminders, _ := store.ListByUser(ctx, "1001", "4001", sent)
+	if len(reminders) != 1 {
+		t.Fatalf("createReminder: FAILED, expected 1 reminder but got %d", len(reminders))
+	}
+	if reminders[0].SourceMessage != "3000" {
+		t.Errorf("createReminder: FAILED, expected source message %v but got %v", "3000", reminders[0].SourceMessage)
+	}
+	if expected := `"raid signups close friday" https://discord.com/channels/1001/2001/3000`; reminders[0].Message != expected {
+		t.Errorf("createReminder: FAILED, expected %v but got %v", expected, reminders[0].Message)
+	}
+
+	// text given with the reply is kept as the message
+	store = newMemoryReminderStore()
+	message.Content = "sign up 2h"
+	createReminder(ctx, store, nil, message, reminderOptions{})
+	reminders, _ = store.ListByUser(ctx, "1001", "4001", sent)
+	if len(reminders) != 1 || strings.TrimSpace(reminders[0].Message) != "sign up" {
+		t.Errorf("createReminder: FAILED, expected message %v but got %v", "sign up", reminders)
+	}
+}
@@ -15,14 +15,109 @@ import (
 )
 
 type botService struct {
-	flags FeatureFlags
+	flags     FeatureFlags
+	denial    denialConfig
+	reminders ReminderStore
+	scheduler *reminderScheduler
+	settings  UserSettingsStore
 }
 
 type FeatureFlags interface {
 	IsFeatureEnabled(featureKey string, userContext entities.UserContext) (bool, error)
 }
 
-//MessageRespond is the handler for which message respond function should be called
+// commandHandler runs a single command once MessageRespond has matched it
+type commandHandler func(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string)
+
+// botCommand describes a command the bot responds to and the feature flag and Discord
+// permissions gating it. Subcommands are matched against the first word after the
+// command and fall back to the parent when none match. Flags the handler checks itself,
+// for parts of a command, are listed in checks so they're declared in flags.json too.
+// Commands marked directMessages can be used in a DM with the bot without their flag.
+type botCommand struct {
+	name           string
+	aliases        []string
+	match          func(command string) bool
+	flag           string
+	checks         []string
+	permissions    int64
+	directMessages bool
+	subcommands    []botCommand
+	handler        commandHandler
+}
+
+func (c botCommand) matches(command string) bool {
+	if c.match != nil {
+		return c.match(command)
+	}
+	if command == c.name {
+		return true
+	}
+	for _, alias := range c.aliases {
+		if command == alias {
+			return true
+		}
+	}
+	return false
+}
+
+func findCommand(commands []botCommand, command string) (botCommand, bool) {
+	for _, c := range commands {
+		if c.matches(command) {
+			return c, true
+		}
+	}
+	return botCommand{}, false
+}
+
+// commands returns every command the bot has registered, in the order they are matched
+func (b *botService) commands() []botCommand {
+	return []botCommand{
+		{name: "help", handler: b.helpCommand},
+		{name: "source", handler: b.sourceCommand},
+		{name: "featurerequest", handler: b.featureRequestCommand},
+		{name: "ping", handler: b.pingCommand},
+		{name: "test", handler: b.testCommand},
+		{name: "split", handler: b.splitCommand},
+		{name: "emoji", handler: b.emojiCommand},
+		{name: "catfact", handler: b.catFactCommand},
+		{name: "relationships", flag: flagRelationship, handler: b.relationshipsCommand},
+		{
+			name:        "mc",
+			flag:        flagMinecraftAdmin,
+			permissions: discordgo.PermissionAdministrator,
+			handler:     b.minecraftCommand,
+			subcommands: []botCommand{
+				{name: "whitelist", flag: flagMinecraft, handler: b.minecraftCommand},
+			},
+		},
+		{name: "mtg", handler: b.magicCommand},
+		{name: "time", flag: flagTimezone, handler: b.timeCommand},
+		{name: "meet", flag: flagTimezone, handler: b.meetCommand},
+		{
+			name: "link",
+			match: func(command string) bool {
+				return strings.Contains(command, "lunch") || strings.HasPrefix(command, "link")
+			},
+			flag:    flagLunch,
+			handler: b.linkCommand,
+		},
+		{name: "kevin", handler: b.kevinCommand},
+		{
+			name:           "remindme",
+			flag:           flagReminder,
+			checks:         []string{flagReminderRoles, flagReminderUnlimited},
+			directMessages: true,
+			handler:        b.reminderCommand,
+		},
+		{name: "forgetme", handler: b.forgetMeCommand},
+		{name: "language", handler: b.languageCommand},
+		{name: "tobefair", aliases: []string{"tbf"}, handler: b.toBeFairCommand},
+		{name: "roll", aliases: []string{"r"}, flag: flagRollDice, handler: b.rollDiceCommand},
+	}
+}
+
+// MessageRespond is the handler for which message respond function should be called
 func (b *botService) MessageRespond(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Author.ID == s.State.User.ID {
 		return
@@ -38,6 +133,11 @@ func (b *botService) MessageRespond(s *discordgo.Session, m *discordgo.MessageCr
 		toBeFairAutoResponse(s, m)
 	}
 
+	if m.GuildID == "" && isReminderPrompt(m.ReferencedMessage, s.State.User.ID) {
+		b.reminderPromptReply(s, m)
+		return
+	}
+
 	if !strings.HasPrefix(m.Content, "!") {
 		return
 	}
@@ -47,6 +147,7 @@ func (b *botService) MessageRespond(s *discordgo.Session, m *discordgo.MessageCr
 	me := hnydiscordgo.MessageEvent{Message: m.Message, Context: ctx}
 
 	ctx, span = hnydiscordgo.StartSpanOrTraceFromMessage(&me, s)
+	defer span.Send()
 
 	m.Content = strings.Replace(m.Content, "!", "", 1)
 	span.AddField("name", "MessageRespond")
@@ -65,243 +166,459 @@ func (b *botService) MessageRespond(s *discordgo.Session, m *discordgo.MessageCr
 	}
 
 	span.AddField("parsedCommand", command)
-	span.AddField("remainingContent", m.Content)
+	span.AddField("remainingContent.length", len(m.Content))
+
+	c, ok := findCommand(b.commands(), command)
+	if !ok {
+		return
+	}
+
+	if len(c.subcommands) > 0 {
+		sub := strings.SplitN(strings.TrimSpace(m.Content), " ", 2)[0]
+		if sc, ok := findCommand(c.subcommands, strings.ToLower(sub)); ok {
+			span.AddField("parsedSubcommand", sc.name)
+			c = sc
+		}
+	}
+
+	if allowed, reason := b.commandAllowed(ctx, s, c, m, roles); !allowed {
+		b.denyCommand(ctx, s, m, c, reason)
+		return
+	}
+
+	if c.flag != "" {
+		span.AddField("flags."+c.flag, true)
+	}
 
-	if command == "help" {
-		span.AddField("command", "help")
-		help := `Commands available:
+	c.handler(ctx, s, m, roles)
+}
+
+func (b *botService) helpCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "help")
+	help := `Commands available:
 		ping - returns pong if bot is running
 		catfact - returns a random cat fact
 		relationships - returns a random relationship objective or synergy
 		mc - runs various minecraft commands if enabled for the user
 		mtg - returns a scryfall search link based on user criteria, see mtg help for more details.
 		source - returns the source of the bot
-		time <username> - returns the time in that users location. Not available everywhere.
+		time <username>/<@user>/<zone> - returns the time in that users location, time set <zone> registers yours.
+		time <time> [day] [<username>/<zone>] [to <usernames/zones>] - converts a time to everyone else's.
+		time all - lists the time for everyone who has registered a timezone, time hours <start>-<end> sets your working hours.
+		meet <@users> [length] - suggests times within everyone's working hours, an hour long by default.
 		remindme <text> <time> - sets a reminder for the future with a specified message.
+		forgetme - deletes your reminders and settings after asking you to confirm.
 		kevin - returns a Home Alone Kevin! gif.
 		tobefair - returns a Letterkenny To Be Fair gif.
 		roll <number>/<help>- rolls the specified number of dice and returns number of successes or returns help.
 		`
-		sendResponse(ctx, s, m.ChannelID, help)
-	} else if command == "source" {
-		span.AddField("command", "source")
-		sendResponse(ctx, s, m.ChannelID, "You can find the source here: https://github.com/ChrisLGardner/go-discord-bot")
-	} else if command == "featurerequest" {
-		span.AddField("command", "featurerequest")
-		resp := featureRequestResponse(ctx, m.Author.ID)
-		sendResponse(ctx, s, m.ChannelID, resp)
-	} else if command == "ping" {
-		span.AddField("command", "ping")
-		sendResponse(ctx, s, m.ChannelID, "pong")
-	} else if command == "test" {
-		span.AddField("command", "test")
-		time.Sleep(3 * time.Second)
-		sendResponse(ctx, s, m.ChannelID, "test success")
-	} else if command == "split" {
-		span.AddField("command", "split")
-		str := strings.Split(m.Content, " ")
-		sendResponse(ctx, s, m.ChannelID, strings.Join(str[1:], "-"))
-	} else if command == "emoji" {
-		span.AddField("command", "emoji-test")
-
-		sendResponse(ctx, s, m.ChannelID, "<:emotest:788860836009345024>")
-	} else if command == "catfact" {
-		span.AddField("command", "catfact")
-
-		fact, err := getCatFact(ctx)
+	sendResponse(ctx, s, m.ChannelID, help)
+}
 
-		if err != nil {
-			span.AddField("error", err)
-			sendResponse(ctx, s, m.ChannelID, "error getting cat fact")
-		}
-		sendResponse(ctx, s, m.ChannelID, fact.Fact)
+func (b *botService) sourceCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "source")
+	sendResponse(ctx, s, m.ChannelID, "You can find the source here: https://github.com/ChrisLGardner/go-discord-bot")
+}
 
-	} else if command == "relationships" {
-		span.AddField("command", "relationships")
+func (b *botService) featureRequestCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "featurerequest")
+	resp := featureRequestResponse(ctx, m.Author.ID)
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
 
-		enabled := false
+func (b *botService) pingCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "ping")
+	sendResponse(ctx, s, m.ChannelID, "pong")
+}
 
-		if getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, "relationship-command") {
-			span.AddField("flags.relationship", true)
-			enabled = true
-		}
+func (b *botService) testCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "test")
+	time.Sleep(3 * time.Second)
+	sendResponse(ctx, s, m.ChannelID, "test success")
+}
 
-		if enabled {
-			rel, err := getRelationship(ctx)
+func (b *botService) splitCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "split")
+	str := strings.Split(m.Content, " ")
+	sendResponse(ctx, s, m.ChannelID, strings.Join(str[1:], "-"))
+}
 
-			if err != nil {
-				span.AddField("error", err)
-				sendResponse(ctx, s, m.ChannelID, "error getting cat fact")
-			}
-			if strings.Contains(m.Content, "objective") {
-				span.AddField("relationship.output.objective", true)
-				sendResponse(ctx, s, m.ChannelID, rel.Objective)
-			} else {
-				span.AddField("relationship.output.synergy", true)
-				sendResponse(ctx, s, m.ChannelID, rel.Synergy)
-			}
-		} else {
-			span.AddField("flags.relationship", false)
-			sendResponse(ctx, s, m.ChannelID, "Command not allowed")
-		}
-	} else if command == "mc" {
-		span.AddField("command", "minecraft")
+func (b *botService) emojiCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "emoji-test")
 
-		enabled := false
+	sendResponse(ctx, s, m.ChannelID, "<:emotest:788860836009345024>")
+}
 
-		if strings.Contains(m.Content, " whitelist ") {
-			if getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, "mc-commands") {
-				span.AddField("flags.minecraft", true)
-				enabled = true
-			}
+func (b *botService) catFactCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "catfact")
 
-			if enabled {
-				resp, err := sendMinecraftCommand(ctx, m.Content)
-				if err != nil {
-					span.AddField("error", err)
-					sendResponse(ctx, s, m.ChannelID, err.Error())
-				}
+	fact, err := getCatFact(ctx)
 
-				sendResponse(ctx, s, m.ChannelID, resp)
-			}
-		} else if getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, "mc-admin") {
-			span.AddField("flags.minecraft", true)
-			span.AddField("flags.minecraft-admin", true)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		sendResponse(ctx, s, m.ChannelID, "error getting cat fact")
+	}
+	sendResponse(ctx, s, m.ChannelID, fact.Fact)
+}
 
-			resp, err := sendMinecraftCommand(ctx, m.Content)
-			if err != nil {
-				span.AddField("error", err)
-				sendResponse(ctx, s, m.ChannelID, err.Error())
-			}
+func (b *botService) relationshipsCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "relationships")
 
-			sendResponse(ctx, s, m.ChannelID, resp)
+	rel, err := getRelationship(ctx)
 
-		} else {
-			span.AddField("flags.minecraft", false)
-			sendResponse(ctx, s, m.ChannelID, "Command not allowed")
-		}
-	} else if command == "mtg" {
-		span.AddField("command", "magic")
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		sendResponse(ctx, s, m.ChannelID, "error getting cat fact")
+	}
+	if strings.Contains(m.Content, "objective") {
+		beeline.AddField(ctx, "relationship.output.objective", true)
+		sendResponse(ctx, s, m.ChannelID, rel.Objective)
+	} else {
+		beeline.AddField(ctx, "relationship.output.synergy", true)
+		sendResponse(ctx, s, m.ChannelID, rel.Synergy)
+	}
+}
+
+func (b *botService) minecraftCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "minecraft")
+
+	resp, err := sendMinecraftCommand(ctx, m.Content)
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		sendResponse(ctx, s, m.ChannelID, err.Error())
+	}
+
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
 
-		str := strings.Replace(m.Content, "mtg", "", 1)
+func (b *botService) magicCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "magic")
 
-		resp, err := mtgCommand(ctx, strings.TrimSpace(str))
+	str := strings.Replace(m.Content, "mtg", "", 1)
+
+	resp, err := mtgCommand(ctx, strings.TrimSpace(str))
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		sendResponse(ctx, s, m.ChannelID, err.Error())
+	} else {
+		sendResponse(ctx, s, m.ChannelID, resp)
+	}
+}
+
+func (b *botService) timeCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "time")
+
+	str := strings.Replace(m.Content, "time ", "", 1)
+
+	if str == "set" || strings.HasPrefix(str, "set ") {
+		resp, err := setTimezone(ctx, b.settings, m.Author.ID, strings.TrimSpace(strings.TrimPrefix(str, "set")), time.Now())
 		if err != nil {
-			span.AddField("error", err)
+			beeline.AddField(ctx, "error", err)
 			sendResponse(ctx, s, m.ChannelID, err.Error())
-		} else {
-			sendResponse(ctx, s, m.ChannelID, resp)
+			return
 		}
-	} else if command == "time" {
-		span.AddField("command", "time")
+		sendResponse(ctx, s, m.ChannelID, resp)
+		return
+	}
 
-		enabled := false
-		if getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, "timezone-command") {
-			span.AddField("flags.timezone", true)
-			enabled = true
+	zones := memberTimezones{settings: b.settings, session: s}
+	lookup := zoneLookup(zones.lookup(m.GuildID))
+
+	var resp string
+	var err error
+	if str == "hours" || strings.HasPrefix(str, "hours ") {
+		resp, err = setWorkHours(ctx, b.settings, m.Author.ID, strings.TrimSpace(strings.TrimPrefix(str, "hours")))
+	} else if str == "all" {
+		var members []zonedName
+		members, err = zones.registered(ctx, m.GuildID)
+		if err == nil {
+			resp, err = worldClock(ctx, members, time.Now())
 		}
+	} else if isTimeConversion(str) {
+		resp, err = convertTime(ctx, str, time.Now(), "<@"+m.Author.ID+">", lookup, func(ctx context.Context) ([]zonedName, error) {
+			return zones.registered(ctx, m.GuildID)
+		})
+	} else {
+		resp, err = getTime(ctx, time.Now(), str, lookup)
+	}
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		sendResponse(ctx, s, m.ChannelID, err.Error())
+	} else {
+		sendResponse(ctx, s, m.ChannelID, resp)
+	}
+}
 
-		if enabled {
-			str := strings.Replace(m.Content, "time ", "", 1)
+func (b *botService) meetCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "meet")
 
-			resp, err := getTime(ctx, time.Now(), strings.ToLower(str))
-			if err != nil {
-				span.AddField("error", err)
-				sendResponse(ctx, s, m.ChannelID, err.Error())
-			} else {
-				sendResponse(ctx, s, m.ChannelID, resp)
-			}
-		} else {
-			span.AddField("flags.timezone", false)
-			sendResponse(ctx, s, m.ChannelID, "Command not allowed")
-		}
-	} else if strings.Contains(command, "lunch") || strings.HasPrefix(command, "link") {
-		span.AddField("command", "link")
+	zones := memberTimezones{settings: b.settings, session: s}
+	resp, err := planMeeting(ctx, zones, m.GuildID, m.Author.ID, m.Content, time.Now())
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		sendResponse(ctx, s, m.ChannelID, err.Error())
+		return
+	}
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
 
-		enabled := false
-		if getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, "lunch-command") {
-			span.AddField("flags.link", true)
-			enabled = true
-		}
+func (b *botService) linkCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "link")
 
-		if enabled {
-			resp := fmt.Sprintf("<@&885649453049069618> %s please don't share this publicly", os.Getenv("LUNCH_LINK"))
-			sendResponse(ctx, s, m.ChannelID, resp)
-		} else {
-			span.AddField("flags.link", false)
-			sendResponse(ctx, s, m.ChannelID, "Command not allowed")
+	resp := fmt.Sprintf("<@&885649453049069618> %s please don't share this publicly", os.Getenv("LUNCH_LINK"))
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
+
+func (b *botService) kevinCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "kevin")
+	resp := kevinResponse(ctx)
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
+
+func (b *botService) reminderCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "reminder")
+	m.Content = strings.Replace(m.Content, "remindme ", "", 1)
+
+	if m.Content == "help" {
+		resp := reminderHelp()
+		sendResponse(ctx, s, m.ChannelID, resp)
+
+	} else if strings.HasPrefix(m.Content, "list") {
+		resp, err := listReminders(ctx, b.reminders, s, m.Message)
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+		}
+		sendResponse(ctx, s, m.ChannelID, resp)
+	} else if strings.HasPrefix(m.Content, "cancel ") {
+		resp, err := cancelReminder(ctx, b.reminders, b.scheduler, m.Message)
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+			return
 		}
-	} else if command == "kevin" {
-		span.AddField("command", "kevin")
-		resp := kevinResponse(ctx)
 		sendResponse(ctx, s, m.ChannelID, resp)
-	} else if command == "remindme" {
-		span.AddField("command", "reminder")
-		m.Content = strings.Replace(m.Content, "remindme ", "", 1)
-
-		enabled := false
-		if getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, "reminder-command") {
-			span.AddField("flags.reminder", true)
-			enabled = true
+	} else if strings.HasPrefix(m.Content, "default") {
+		resp, err := setReminderDefault(ctx, b.settings, m.Message, b.reminderOptions(ctx, s, m, roles))
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+			return
+		}
+		sendResponse(ctx, s, m.ChannelID, resp)
+	} else if m.Content == "optout" || m.Content == "optin" {
+		resp, err := setReminderOptOut(ctx, b.settings, m.Author.ID, m.Content == "optout")
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+			return
+		}
+		sendResponse(ctx, s, m.ChannelID, resp)
+	} else if m.Content == "export" || m.Content == "export reset" {
+		message, err := exportReminders(ctx, b.reminders, b.settings, m.Author.ID, m.Content == "export reset", time.Now())
+		if err == nil {
+			err = sendDM(s, m.Author.ID, message)
+		}
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, "Couldn't send your reminders, check you accept DMs from server members")
+			return
+		}
+		if m.GuildID != "" {
+			sendResponse(ctx, s, m.ChannelID, "I've sent you a DM with your reminders.")
+		}
+	} else if m.Content == "export revoke" {
+		resp, err := revokeCalendarFeed(ctx, b.settings, m.Author.ID)
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+			return
+		}
+		sendResponse(ctx, s, m.ChannelID, resp)
+	} else if m.Content == "import" {
+		if len(m.Attachments) == 0 {
+			sendResponse(ctx, s, m.ChannelID, "Attach an .ics calendar file to import")
+			return
+		}
+		data, err := downloadCalendar(ctx, m.Attachments[0])
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+			return
+		}
+		resp, err := importReminders(ctx, b.reminders, b.scheduler, m.Message, data, b.reminderOptions(ctx, s, m, roles))
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+			return
+		}
+		sendResponse(ctx, s, m.ChannelID, resp)
+	} else if strings.HasPrefix(m.Content, "edit ") {
+		resp, err := editReminder(ctx, b.reminders, b.scheduler, m.Message, b.reminderOptions(ctx, s, m, roles))
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+			return
+		}
+		sendResponse(ctx, s, m.ChannelID, resp)
+	} else {
+		resp, err := createReminder(ctx, b.reminders, b.scheduler, m.Message, b.reminderOptions(ctx, s, m, roles))
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
 		}
+		sendResponse(ctx, s, m.ChannelID, resp)
+	}
+}
 
-		if enabled {
-			if m.Content == "help" {
-				resp := reminderHelp()
-				sendResponse(ctx, s, m.ChannelID, resp)
-
-			} else if strings.HasPrefix(m.Content, "list") {
-				resp, err := listReminders(ctx, s, m.Message)
-				if err != nil {
-					span.AddField("error", err)
-					sendResponse(ctx, s, m.ChannelID, err.Error())
-				}
-				sendResponse(ctx, s, m.ChannelID, resp)
-			} else {
-				resp, err := createReminder(ctx, m.Message)
-				if err != nil {
-					span.AddField("error", err)
-					sendResponse(ctx, s, m.ChannelID, err.Error())
-				}
-				sendResponse(ctx, s, m.ChannelID, resp)
+// reminderOptions describes what the author of a remindme command is allowed to do.
+// Server admins, and anyone with the reminder-unlimited flag, aren't limited.
+func (b *botService) reminderOptions(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) reminderOptions {
+	return reminderOptions{
+		loc:             reminderLocation(ctx, s, b.settings, m.Message),
+		canMentionRoles: m.GuildID != "" && b.allowed(ctx, s, m, roles, flagReminderRoles, discordgo.PermissionMentionEveryone),
+		settings:        b.settings,
+		canPostIn: func(channelID string) bool {
+			perms, err := memberChannelPermissions(s, m.Author.ID, channelID)
+			if err != nil {
+				beeline.AddField(ctx, "permissions.error", err)
+				return false
 			}
-		} else {
-			span.AddField("flags.reminder", false)
+			return hasPermissions(perms, discordgo.PermissionViewChannel|discordgo.PermissionSendMessages)
+		},
+		limits: b.reminderLimits(ctx, s, m, roles),
+	}
+}
 
-		}
-	} else if command == "language" {
-		span.AddField("command", "language")
-		resp := languageResponse(ctx)
+// reminderLimits are the limits on the author's reminders, none if they're allowed
+// unlimited reminders on the server
+func (b *botService) reminderLimits(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) reminderLimits {
+	if m.GuildID != "" && b.allowed(ctx, s, m, roles, flagReminderUnlimited, discordgo.PermissionManageServer) {
+		beeline.AddField(ctx, "remindme.unlimited", true)
+		return reminderLimits{}
+	}
+	return reminderLimitsFromEnv()
+}
+
+func (b *botService) languageCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "language")
+	resp := languageResponse(ctx)
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
+
+func (b *botService) toBeFairCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "tobefair")
+	resp := toBeFairResponse(ctx)
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
+
+func (b *botService) rollDiceCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "rolldice")
+
+	if m.Content == "help" {
+		resp := rollDiceHelp()
 		sendResponse(ctx, s, m.ChannelID, resp)
-	} else if command == "tobefair" || command == "tbf" {
-		span.AddField("command", "tobefair")
-		resp := toBeFairResponse(ctx)
+	} else {
+		resp, err := rollDice(ctx, m.Content)
+		if err != nil {
+			beeline.AddField(ctx, "error", err)
+			sendResponse(ctx, s, m.ChannelID, err.Error())
+		}
 		sendResponse(ctx, s, m.ChannelID, resp)
-	} else if command == "r" || command == "roll" {
-		span.AddField("command", "rolldice")
+	}
+}
 
-		enabled := false
-		if getFeatureFlagState(ctx, b.flags, m.Author.ID, roles, "rolldice-command") {
-			span.AddField("flags.rolldice", true)
-			enabled = true
-		}
+// forgetMeCommand DMs the user what forgetme would delete, with a button to confirm it
+func (b *botService) forgetMeCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) {
+	beeline.AddField(ctx, "command", "forgetme")
 
-		if enabled {
-			if m.Content == "help" {
-				resp := rollDiceHelp()
-				sendResponse(ctx, s, m.ChannelID, resp)
-			} else {
-				resp, err := rollDice(ctx, m.Content)
-				if err != nil {
-					span.AddField("error", err)
-					sendResponse(ctx, s, m.ChannelID, err.Error())
-				}
-				sendResponse(ctx, s, m.ChannelID, resp)
-			}
+	message, err := forgetMePrompt(ctx, b.reminders, b.settings, m.Author.ID)
+	if err == nil {
+		err = sendDM(s, m.Author.ID, message)
+	}
+	if err != nil {
+		beeline.AddField(ctx, "error", err)
+		sendResponse(ctx, s, m.ChannelID, "Couldn't send you the details, check you accept DMs from server members")
+		return
+	}
+	if m.GuildID != "" {
+		sendResponse(ctx, s, m.ChannelID, "I've sent you a DM to confirm.")
+	}
+}
+
+// InteractionRespond handles button presses, the snooze buttons on delivered reminders,
+// the times offered by a reminder prompt and confirming forgetme
+func (b *botService) InteractionRespond(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionMessageComponent {
+		return
+	}
+
+	ctx, span := beeline.StartSpan(context.Background(), "InteractionRespond")
+	defer span.Send()
+
+	data := i.MessageComponentData()
+	span.AddField("interactionRespond.customID", data.CustomID)
+	span.AddField("interactionRespond.guildID", i.GuildID)
+	span.AddField("interactionRespond.channelID", i.ChannelID)
+
+	user := i.User
+	if i.Member != nil {
+		user = i.Member.User
+	}
+	span.AddField("interactionRespond.user", user.ID)
+
+	var resp string
+	var err error
+	switch {
+	case strings.HasPrefix(data.CustomID, snoozeCustomIDPrefix):
+		// custom IDs are remindme:snooze:<id>:<duration>
+		parts := strings.SplitN(strings.TrimPrefix(data.CustomID, snoozeCustomIDPrefix), ":", 2)
+		if len(parts) != 2 {
+			span.AddField("interactionRespond.error", "malformed custom ID")
+			return
 		}
+		presser := &discordgo.MessageCreate{Message: &discordgo.Message{
+			ChannelID: i.ChannelID,
+			GuildID:   i.GuildID,
+			Author:    user,
+		}}
+		roles, roleErr := getMemberRoles(ctx, s, presser.Message)
+		if roleErr != nil {
+			span.AddField("member.role.error", roleErr)
+		}
+		var roleIDs []string
+		if i.Member != nil {
+			roleIDs = i.Member.Roles
+		}
+		resp, err = snoozeReminder(ctx, b.reminders, b.scheduler, parts[0], user.ID, roleIDs, parts[1], time.Now(), b.reminderLimits(ctx, s, presser, roles))
+	case strings.HasPrefix(data.CustomID, promptCustomIDPrefix):
+		// custom IDs are remindme:prompt:<time>, the message is in the prompt itself
+		resp, err = b.answerReminderPrompt(ctx, s, i.Message, user, strings.TrimPrefix(data.CustomID, promptCustomIDPrefix))
+	case strings.HasPrefix(data.CustomID, forgetMeCustomIDPrefix):
+		// custom IDs are forgetme:<user>, only that user can confirm
+		if strings.TrimPrefix(data.CustomID, forgetMeCustomIDPrefix) != user.ID {
+			resp = "Only the person who asked can confirm this"
+			break
+		}
+		resp, err = forgetUser(ctx, b.reminders, b.settings, b.scheduler, user.ID)
+	default:
+		return
+	}
+	if err != nil {
+		span.AddField("interactionRespond.error", err)
+		resp = err.Error()
 	}
 
-	span.Send()
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: resp,
+			Flags:   uint64(discordgo.MessageFlagsEphemeral),
+		},
+	})
+	if err != nil {
+		span.AddField("interactionRespond.error", err)
+	}
 }
 
 func (b *botService) MessageReact(s *discordgo.Session, mra *discordgo.MessageReactionAdd) {
@@ -331,7 +648,7 @@ func (b *botService) MessageReact(s *discordgo.Session, mra *discordgo.MessageRe
 	span.AddField("messageReact.originalMessage.id", message.ID)
 	span.AddField("messageReact.originalMessage.guildID", message.GuildID)
 	span.AddField("messageReact.originalMessage.channelID", message.ChannelID)
-	span.AddField("messageReact.originalMessage.content", message.Content)
+	span.AddField("messageReact.originalMessage.length", len(message.Content))
 	span.AddField("messageReact.originalMessage.author.id", message.Author.ID)
 	span.AddField("messageReact.originalMessage.author.username", message.Author.Username)
 
@@ -351,25 +668,105 @@ func (b *botService) MessageReact(s *discordgo.Session, mra *discordgo.MessageRe
 		span.AddField("reaction", "language")
 		resp := languageResponse(ctx)
 		sendReply(ctx, s, resp, message.MessageReference)
+	} else if mra.Emoji.Name == reminderEmoji() {
+		span.AddField("reaction", "remindme")
+		b.promptForReminder(ctx, s, mra)
 	}
 	span.Send()
 }
 
+// promptForReminder DMs someone who reacted with the reminder emoji, asking when to
+// remind them about the message. Reactions are quiet, so anyone not allowed to use
+// remindme is ignored rather than told off.
+func (b *botService) promptForReminder(ctx context.Context, s *discordgo.Session, mra *discordgo.MessageReactionAdd) {
+	reactor := &discordgo.MessageCreate{Message: &discordgo.Message{
+		ChannelID: mra.ChannelID,
+		GuildID:   mra.GuildID,
+		Author:    &discordgo.User{ID: mra.UserID},
+	}}
+
+	roles, err := getMemberRoles(ctx, s, reactor.Message)
+	if err != nil {
+		beeline.AddField(ctx, "member.role.error", err)
+	}
+	if !b.reminderAllowed(ctx, s, reactor, roles) {
+		return
+	}
+
+	if err := sendDM(s, mra.UserID, reminderPrompt(mra.GuildID, mra.ChannelID, mra.MessageID)); err != nil {
+		beeline.AddField(ctx, "messageReact.error", err)
+	}
+}
+
+// reminderPromptReply creates a reminder from a DM replying to a reminder prompt
+func (b *botService) reminderPromptReply(s *discordgo.Session, m *discordgo.MessageCreate) {
+	ctx, span := beeline.StartSpan(context.Background(), "reminderPromptReply")
+	defer span.Send()
+
+	resp, err := b.answerReminderPrompt(ctx, s, m.ReferencedMessage, m.Author, m.Content)
+	if err != nil {
+		span.AddField("reminderPromptReply.error", err)
+		resp = err.Error()
+	}
+	sendResponse(ctx, s, m.ChannelID, resp)
+}
+
+// answerReminderPrompt sets the reminder a prompt asked for, at the time the user picked
+func (b *botService) answerReminderPrompt(ctx context.Context, s *discordgo.Session, prompt *discordgo.Message, user *discordgo.User, answer string) (string, error) {
+	message, err := promptedReminder(prompt, user, answer, time.Now())
+	if err != nil {
+		return "", err
+	}
+	m := &discordgo.MessageCreate{Message: message}
+
+	roles, err := getMemberRoles(ctx, s, message)
+	if err != nil {
+		beeline.AddField(ctx, "member.role.error", err)
+	}
+	if !b.reminderAllowed(ctx, s, m, roles) {
+		return "", fmt.Errorf("You can't set reminders there")
+	}
+
+	// quote the message in the reminder if it can still be read
+	if ref, err := s.ChannelMessage(message.MessageReference.ChannelID, message.MessageReference.MessageID); err == nil {
+		message.ReferencedMessage = ref
+	}
+
+	return createReminder(ctx, b.reminders, b.scheduler, message, b.reminderOptions(ctx, s, m, roles))
+}
+
+// reminderAllowed reports whether the author can use remindme where the message was sent
+func (b *botService) reminderAllowed(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, roles []string) bool {
+	c, ok := findCommand(b.commands(), "remindme")
+	if !ok {
+		return false
+	}
+
+	allowed, reason := b.commandAllowed(ctx, s, c, m, roles)
+	beeline.AddField(ctx, "remindme.denied", reason)
+	return allowed
+}
+
 func getMemberRoles(ctx context.Context, s *discordgo.Session, m *discordgo.Message) ([]string, error) {
 	ctx, span := beeline.StartSpan(ctx, "get_discord_role")
 	defer span.Send()
 
-	member, err := s.GuildMember(m.GuildID, m.Author.ID)
 	beeline.AddField(ctx, "role.guidid", m.GuildID)
 	beeline.AddField(ctx, "role.author.id", m.Author.ID)
 	beeline.AddField(ctx, "role.author.name", m.Author.Username)
 
+	// direct messages have no guild so no roles
+	if m.GuildID == "" {
+		return nil, nil
+	}
+
+	member, err := guildMember(ctx, s, m.GuildID, m.Author.ID)
 	if err != nil {
 		beeline.AddField(ctx, "error", err)
 		return nil, err
 	}
 
-	guildRoles, err := s.GuildRoles(m.GuildID)
+	guildRoles, err := guildRoles(ctx, s, m.GuildID)
 
 	if err != nil {
 		beeline.AddField(ctx, "error", err)
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+	"github.com/honeycombio/beeline-go"
+	"github.com/honeycombio/beeline-go/trace"
+)
+
+// reminderQueue is a min-heap of reminders ordered by due time
+type reminderQueue []Reminder
+
+func (q reminderQueue) Len() int            { return len(q) }
+func (q reminderQueue) Less(i, j int) bool  { return q[i].fireAt().Before(q[j].fireAt()) }
+func (q reminderQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *reminderQueue) Push(x interface{}) { *q = append(*q, x.(Reminder)) }
+func (q *reminderQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	r := old[n-1]
+	*q = old[:n-1]
+	return r
+}
+
+// reminderClaimTTL is how long an instance has to send a reminder it has claimed before
+// another can try
+const reminderClaimTTL = 5 * time.Minute
+
+// reminderScheduler fires each reminder at its due time. It keeps the reminders due
+// before the next refresh in a heap, reloading from the store every interval, and
+// delivers anything overdue and undelivered as soon as it starts. Reminders due soon are
+// also polled for every poll, so ones created on other replicas aren't late. Transient
+// delivery failures are retried with backoff up to maxAttempts. Each reminder is claimed
+// for the instance before it's sent, so it's never sent twice even if two instances fire
+// it.
+type reminderScheduler struct {
+	store       ReminderStore
+	deliver     func(ctx context.Context, r Reminder) error
+	interval    time.Duration
+	poll        time.Duration
+	maxAttempts int
+	backoff     func(attempts int) time.Duration
+	instance    string
+
+	mu      sync.Mutex
+	running bool
+	queue   reminderQueue
+	queued  map[string]bool
+	wake    chan struct{}
+}
+
+func newReminderScheduler(store ReminderStore, interval time.Duration, deliver func(ctx context.Context, r Reminder) error) *reminderScheduler {
+	return &reminderScheduler{
+		store:       store,
+		deliver:     deliver,
+		interval:    interval,
+		poll:        time.Duration(envOrDefaultInt("REMINDER_POLL_SECONDS", 15)) * time.Second,
+		maxAttempts: envOrDefaultInt("REMINDER_MAX_ATTEMPTS", 5),
+		backoff:     retryBackoff,
+		instance:    instanceID(),
+		queued:      make(map[string]bool),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// horizon is how far ahead reminders are held in memory. It's longer than the refresh
+// interval so nothing falls between two loads.
+func (s *reminderScheduler) horizon() time.Duration {
+	return 2 * s.interval
+}
+
+// Schedule queues a newly created reminder so it fires without waiting for a refresh.
+// Replicas that aren't running the scheduler leave it for the leader to poll.
+func (s *reminderScheduler) Schedule(r Reminder) {
+	if s == nil || r.Due.After(time.Now().Add(s.horizon())) {
+		return
+	}
+
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.push(r)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Unschedule drops a reminder from the queue, e.g. when it's been cancelled or moved
+func (s *reminderScheduler) Unschedule(id string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.queued[id] {
+		return
+	}
+	for i, r := range s.queue {
+		if r.ID == id {
+			heap.Remove(&s.queue, i)
+			break
+		}
+	}
+	delete(s.queued, id)
+}
+
+// push adds the reminder, replacing the queued copy if it has been moved since, e.g. by
+// an edit or snooze on another replica. The caller must hold mu.
+func (s *reminderScheduler) push(r Reminder) {
+	if !s.queued[r.ID] {
+		s.queued[r.ID] = true
+		heap.Push(&s.queue, r)
+		return
+	}
+
+	for i, q := range s.queue {
+		if q.ID == r.ID {
+			if !q.Due.Equal(r.Due) || !q.NextAttempt.Equal(r.NextAttempt) {
+				s.queue[i] = r
+				heap.Fix(&s.queue, i)
+			}
+			return
+		}
+	}
+}
+
+// Run delivers reminders until the context is cancelled
+func (s *reminderScheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.running = true
+	s.mu.Unlock()
+
+	defer func() {
+		// whoever leads next loads the queue from the store
+		s.mu.Lock()
+		s.running = false
+		s.queue = nil
+		s.queued = make(map[string]bool)
+		s.mu.Unlock()
+	}()
+
+	s.refresh(ctx)
+
+	refresh := time.NewTicker(s.interval)
+	defer refresh.Stop()
+	poll := time.NewTicker(s.poll)
+	defer poll.Stop()
+
+	for {
+		timer := time.NewTimer(s.untilNext())
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-refresh.C:
+			s.refresh(ctx)
+		case <-poll.C:
+			s.load(ctx, "reminderScheduler.poll", 2*s.poll)
+		case <-s.wake:
+		case <-timer.C:
+			s.fireDue(ctx)
+		}
+
+		timer.Stop()
+	}
+}
+
+func (s *reminderScheduler) untilNext() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return s.interval
+	}
+
+	wait := time.Until(s.queue[0].fireAt())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// refresh loads every undelivered reminder due before the horizon, including overdue
+// ones missed while the bot was down
+func (s *reminderScheduler) refresh(ctx context.Context) {
+	s.load(ctx, "reminderScheduler.refresh", s.horizon())
+}
+
+// load queues every undelivered reminder due within ahead
+func (s *reminderScheduler) load(ctx context.Context, name string, ahead time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	ctx, span := beeline.StartSpan(ctx, name)
+	defer span.Send()
+
+	reminders, err := s.store.Due(ctx, time.Time{}, time.Now().Add(ahead))
+	if err != nil {
+		span.AddField(name+".error", err)
+		return
+	}
+
+	s.mu.Lock()
+	for _, r := range reminders {
+		s.push(r)
+	}
+	span.AddField(name+".loaded", len(reminders))
+	span.AddField(name+".queued", len(s.queue))
+	s.mu.Unlock()
+}
+
+// fireDue delivers every queued reminder that is now due
+func (s *reminderScheduler) fireDue(ctx context.Context) {
+	now := time.Now()
+
+	var due []Reminder
+	s.mu.Lock()
+	for len(s.queue) > 0 && !s.queue[0].fireAt().After(now) {
+		r := heap.Pop(&s.queue).(Reminder)
+		delete(s.queued, r.ID)
+		due = append(due, r)
+	}
+	s.mu.Unlock()
+
+	for _, r := range due {
+		s.fire(ctx, r)
+	}
+}
+
+func (s *reminderScheduler) fire(ctx context.Context, r Reminder) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	ctx, span := beeline.StartSpan(ctx, "sendReminderIndividual")
+	defer span.Send()
+
+	span.AddField("sendReminderIndividual.id", r.ID)
+	span.AddField("sendReminderIndividual.due", r.Due)
+	span.AddField("sendReminderIndividual.lateness_ms", time.Since(r.Due).Milliseconds())
+
+	span.AddField("sendReminderIndividual.attempt", r.Attempts+1)
+
+	claimed, err := s.store.Claim(ctx, r, s.instance, time.Now().Add(reminderClaimTTL))
+	if err != nil {
+		// it's picked up again on the next refresh
+		span.AddField("sendReminderIndividual.claim.error", err)
+		return
+	}
+	if !claimed {
+		span.AddField("sendReminderIndividual.status", "claimed")
+		return
+	}
+
+	// the queued copy may be stale, e.g. a recipient has since been removed on another
+	// replica, so send what's stored now
+	r, err = s.store.Get(ctx, r.ID)
+	if errors.Is(err, gosmosdb.ErrNotFound) {
+		span.AddField("sendReminderIndividual.status", "deleted")
+		return
+	}
+	if err != nil {
+		// the claim lapses and it's picked up again on a later refresh
+		span.AddField("sendReminderIndividual.store.error", err)
+		return
+	}
+
+	err = s.deliver(ctx, r)
+	if err == nil {
+		s.delivered(ctx, span, r)
+		return
+	}
+
+	span.AddField("sendReminderIndividual.error", err)
+	r.Attempts++
+
+	var retryAt time.Time
+	if isTransientError(err) && r.Attempts < s.maxAttempts {
+		retryAt = time.Now().Add(s.backoff(r.Attempts))
+		span.AddField("sendReminderIndividual.status", reminderPending)
+		span.AddField("sendReminderIndividual.retryAt", retryAt)
+	} else {
+		span.AddField("sendReminderIndividual.status", reminderFailed)
+	}
+
+	if err := s.store.RecordFailure(ctx, r.ID, r.Attempts, err.Error(), retryAt); err != nil {
+		span.AddField("sendReminderIndividual.store.error", err)
+		return
+	}
+
+	if !retryAt.IsZero() {
+		r.NextAttempt = retryAt
+		s.mu.Lock()
+		s.push(r)
+		s.mu.Unlock()
+	}
+}
+
+// delivered marks a one-off reminder as sent, or moves a recurring one on to its next
+// occurrence
+func (s *reminderScheduler) delivered(ctx context.Context, span *trace.Span, r Reminder) {
+	if r.Recurrence != nil {
+		if next, ok := r.Recurrence.nextAfter(r.Due, time.Now()); ok {
+			span.AddField("sendReminderIndividual.status", reminderPending)
+			span.AddField("sendReminderIndividual.next", next)
+
+			if err := s.store.Reschedule(ctx, r.ID, next); err != nil {
+				span.AddField("sendReminderIndividual.store.error", err)
+				return
+			}
+
+			r.Due = next
+			r.Attempts = 0
+			r.NextAttempt = time.Time{}
+			s.Schedule(r)
+			return
+		}
+	}
+
+	span.AddField("sendReminderIndividual.status", reminderDelivered)
+	if err := s.store.MarkDelivered(ctx, r.ID); err != nil {
+		span.AddField("sendReminderIndividual.store.error", err)
+	}
+}
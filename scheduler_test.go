@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+type recordingDeliverer struct {
+	mu        sync.Mutex
+	delivered []string
+	at        map[string]time.Time
+	signal    chan string
+}
+
+func newRecordingDeliverer() *recordingDeliverer {
+	return &recordingDeliverer{at: make(map[string]time.Time), signal: make(chan string, 10)}
+}
+
+func (d *recordingDeliverer) deliver(ctx context.Context, r Reminder) error {
+	d.mu.Lock()
+	d.delivered = append(d.delivered, r.ID)
+	d.at[r.ID] = time.Now()
+	d.mu.Unlock()
+	d.signal <- r.ID
+	return nil
+}
+
+func waitForDelivery(t *testing.T, d *recordingDeliverer, id string) {
+	t.Helper()
+	select {
+	case got := <-d.signal:
+		if got != id {
+			t.Fatalf("scheduler: FAILED, expected %v to be delivered but got %v", id, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("scheduler: FAILED, %v was never delivered", id)
+	}
+}
+
+func TestSchedulerCatchesUpOverdue(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemoryReminderStore()
+	overdue, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Hour), BotSource: reminderBotSource})
+	delivered, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Hour), BotSource: reminderBotSource, Status: reminderDelivered})
+
+	d := newRecordingDeliverer()
+	scheduler := newReminderScheduler(store, time.Minute, d.deliver)
+	go scheduler.Run(ctx)
+
+	waitForDelivery(t, d, overdue.ID)
+
+	select {
+	case got := <-d.signal:
+		t.Errorf("scheduler: FAILED, expected only the overdue reminder but %v was also delivered (%v)", got, delivered.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if due, _ := store.Due(ctx, time.Time{}, time.Now()); len(due) != 0 {
+		t.Errorf("scheduler: FAILED, expected the reminder to be marked delivered but %v are still due", len(due))
+	}
+}
+
+func TestSchedulerFiresNewReminderOnTime(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemoryReminderStore()
+	d := newRecordingDeliverer()
+	scheduler := newReminderScheduler(store, time.Minute, d.deliver)
+	go scheduler.Run(ctx)
+
+	// due is stored to the millisecond, and the scheduler may load it before it's scheduled
+	due := time.Now().Add(200 * time.Millisecond).Truncate(time.Millisecond)
+	r, _ := store.Create(ctx, Reminder{Due: due, BotSource: reminderBotSource})
+	scheduler.Schedule(r)
+
+	waitForDelivery(t, d, r.ID)
+
+	d.mu.Lock()
+	at := d.at[r.ID]
+	d.mu.Unlock()
+
+	if at.Before(due) {
+		t.Errorf("scheduler: FAILED, reminder due at %v was delivered early at %v", due, at)
+	}
+	if at.Sub(due) > time.Second {
+		t.Errorf("scheduler: FAILED, reminder due at %v was delivered late at %v", due, at)
+	}
+}
+
+func TestSchedulerPollsForRemindersFromOtherReplicas(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemoryReminderStore()
+	d := newRecordingDeliverer()
+	leader := newReminderScheduler(store, time.Minute, d.deliver)
+	leader.poll = 50 * time.Millisecond
+	go leader.Run(ctx)
+
+	// created on a replica that isn't leading, so only the store knows about it
+	replica := newReminderScheduler(store, time.Minute, d.deliver)
+	r, _ := store.Create(ctx, Reminder{Due: time.Now().Add(300 * time.Millisecond), BotSource: reminderBotSource})
+	replica.Schedule(r)
+
+	replica.mu.Lock()
+	if len(replica.queue) != 0 {
+		t.Errorf("scheduler: FAILED, expected a replica that isn't running not to queue reminders but it has %v", len(replica.queue))
+	}
+	replica.mu.Unlock()
+
+	waitForDelivery(t, d, r.ID)
+
+	d.mu.Lock()
+	at := d.at[r.ID]
+	d.mu.Unlock()
+
+	if at.Sub(r.Due) > time.Second {
+		t.Errorf("scheduler: FAILED, reminder due at %v was delivered late at %v", r.Due, at)
+	}
+}
+
+func TestSchedulerPicksUpRemindersMovedElsewhere(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemoryReminderStore()
+	r, _ := store.Create(ctx, Reminder{Due: time.Now().Add(90 * time.Second), BotSource: reminderBotSource})
+
+	d := newRecordingDeliverer()
+	leader := newReminderScheduler(store, time.Minute, d.deliver)
+	leader.poll = 50 * time.Millisecond
+	go leader.Run(ctx)
+
+	// wait for the leader to queue it at the original time
+	for i := 0; i < 100; i++ {
+		leader.mu.Lock()
+		queued := leader.queued[r.ID]
+		leader.mu.Unlock()
+		if queued {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// another replica moves it earlier, which it can only do in the store
+	replica := newReminderScheduler(store, time.Minute, d.deliver)
+	due := time.Now().Add(300 * time.Millisecond).Truncate(time.Millisecond)
+	store.Reschedule(ctx, r.ID, due)
+	r.Due = due
+	replica.Unschedule(r.ID)
+	replica.Schedule(r)
+
+	waitForDelivery(t, d, r.ID)
+
+	d.mu.Lock()
+	at := d.at[r.ID]
+	d.mu.Unlock()
+
+	if at.Sub(due) > time.Second {
+		t.Errorf("scheduler: FAILED, reminder moved to %v was delivered late at %v", due, at)
+	}
+}
+
+func TestSchedulerRetriesTransientFailures(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemoryReminderStore()
+	flaky, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Minute), BotSource: reminderBotSource})
+	broken, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Minute), BotSource: reminderBotSource})
+
+	d := newRecordingDeliverer()
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	deliver := func(ctx context.Context, r Reminder) error {
+		mu.Lock()
+		attempts[r.ID]++
+		n := attempts[r.ID]
+		mu.Unlock()
+
+		if r.ID == broken.ID {
+			return &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusForbidden}}
+		}
+		if n < 3 {
+			return &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusBadGateway}}
+		}
+		return d.deliver(ctx, r)
+	}
+
+	scheduler := newReminderScheduler(store, time.Minute, deliver)
+	scheduler.backoff = func(int) time.Duration { return 10 * time.Millisecond }
+	go scheduler.Run(ctx)
+
+	waitForDelivery(t, d, flaky.ID)
+
+	mu.Lock()
+	if attempts[flaky.ID] != 3 {
+		t.Errorf("scheduler: FAILED, expected 3 attempts at the flaky reminder but got %v", attempts[flaky.ID])
+	}
+	if attempts[broken.ID] != 1 {
+		t.Errorf("scheduler: FAILED, expected a permanent failure to be tried once but got %v", attempts[broken.ID])
+	}
+	mu.Unlock()
+
+	// the store is updated just after delivery returns
+	time.Sleep(50 * time.Millisecond)
+
+	reminders, _ := store.ListByGuild(ctx, "", time.Time{})
+	for _, r := range reminders {
+		switch r.ID {
+		case flaky.ID:
+			if r.Status != reminderDelivered || r.Attempts != 2 {
+				t.Errorf("scheduler: FAILED, expected flaky reminder delivered after 2 failures but got %v after %v", r.Status, r.Attempts)
+			}
+		case broken.ID:
+			if r.Status != reminderFailed || r.LastError == "" {
+				t.Errorf("scheduler: FAILED, expected broken reminder failed with an error but got %v %q", r.Status, r.LastError)
+			}
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		10: 30 * time.Minute,
+	}
+
+	for attempts, want := range tests {
+		if got := retryBackoff(attempts); got != want {
+			t.Errorf("retryBackoff(%v): FAILED, expected %v but got %v", attempts, want, got)
+		}
+	}
+}
+
+func TestSchedulerReschedulesRecurring(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newMemoryReminderStore()
+	due := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	r, _ := store.Create(ctx, Reminder{Due: due, BotSource: reminderBotSource, Recurrence: &Recurrence{Every: 1, Unit: recurDay, Timezone: "UTC"}})
+
+	d := newRecordingDeliverer()
+	scheduler := newReminderScheduler(store, time.Minute, d.deliver)
+	go scheduler.Run(ctx)
+
+	waitForDelivery(t, d, r.ID)
+
+	// the store is updated just after delivery returns
+	time.Sleep(50 * time.Millisecond)
+
+	got, err := store.Get(ctx, r.ID)
+	if err != nil {
+		t.Fatalf("scheduler: FAILED, expected the recurring reminder to be kept but got %v", err)
+	}
+	if want := due.AddDate(0, 0, 1); got.Status != reminderPending || !got.Due.Equal(want) {
+		t.Errorf("scheduler: FAILED, expected pending at %v but got %v at %v", want, got.Status, got.Due)
+	}
+}
+
+func TestSchedulerClaimsBeforeSending(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+	r, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Minute), BotSource: reminderBotSource})
+
+	// a second replica with a copy of the reminder claimed first
+	if ok, err := store.Claim(ctx, r, "other", time.Now().Add(time.Minute)); !ok || err != nil {
+		t.Fatalf("Claim: FAILED, expected to claim %v but got %v %v", r.ID, ok, err)
+	}
+
+	d := newRecordingDeliverer()
+	scheduler := newReminderScheduler(store, time.Minute, d.deliver)
+	scheduler.fire(ctx, r)
+
+	if len(d.delivered) != 0 {
+		t.Errorf("scheduler: FAILED, expected a reminder claimed elsewhere not to be sent but sent %v", d.delivered)
+	}
+
+	// the claim has lapsed, e.g. the other replica died mid-send
+	store.update(ctx, r.ID, gosmosdb.Update{"claimedUntil": time.Now().Add(-time.Second)})
+	scheduler.fire(ctx, r)
+
+	if len(d.delivered) != 1 {
+		t.Errorf("scheduler: FAILED, expected the reminder to be sent once its claim lapsed but sent %v", d.delivered)
+	}
+
+	// a stale copy of a reminder that has since moved isn't sent
+	moved, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Minute), BotSource: reminderBotSource})
+	store.Reschedule(ctx, moved.ID, time.Now().Add(time.Hour))
+	scheduler.fire(ctx, moved)
+
+	if len(d.delivered) != 1 {
+		t.Errorf("scheduler: FAILED, expected a stale reminder not to be sent but sent %v", d.delivered)
+	}
+}
+
+func TestSchedulerSendsStoredCopy(t *testing.T) {
+
+	ctx := context.Background()
+	store := newMemoryReminderStore()
+
+	var sent []Reminder
+	scheduler := newReminderScheduler(store, time.Minute, func(ctx context.Context, r Reminder) error {
+		sent = append(sent, r)
+		return nil
+	})
+
+	// another replica removed a recipient, e.g. for forgetme, after this copy was queued
+	r, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Minute), Creator: "4001", Recipients: []string{"4002", "4003"}, BotSource: reminderBotSource})
+	store.SetRecipients(ctx, r.ID, []string{"4003"})
+	scheduler.fire(ctx, r)
+
+	if len(sent) != 1 || len(sent[0].Recipients) != 1 || sent[0].Recipients[0] != "4003" {
+		t.Errorf("scheduler: FAILED, expected the reminder to be sent to the stored recipients but sent %v", sent)
+	}
+
+	// and one deleted after it was queued isn't sent
+	deleted, _ := store.Create(ctx, Reminder{Due: time.Now().Add(-time.Minute), Creator: "4001", BotSource: reminderBotSource})
+	store.Delete(ctx, deleted.ID)
+	scheduler.fire(ctx, deleted)
+
+	if len(sent) != 1 {
+		t.Errorf("scheduler: FAILED, expected a deleted reminder not to be sent but sent %v", sent)
+	}
+}
@@ -2,21 +2,37 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
 	"github.com/honeycombio/beeline-go"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Connect to the specified mongo instance using the context for timeout
+// Connect to the specified mongo instance using the context for timeout. The client
+// keeps a pool of connections and is meant to be shared for the life of the process.
 func connectDb(ctx context.Context, uri string) (*mongo.Client, error) {
 	ctx, span := beeline.StartSpan(ctx, "mongo.connect")
 	defer span.Send()
 
-	span.AddField("mongo.server", uri)
+	maxPool := envOrDefaultInt("COSMOSDB_MAX_POOL_SIZE", 10)
+	minPool := envOrDefaultInt("COSMOSDB_MIN_POOL_SIZE", 1)
+
+	span.AddField("mongo.pool.max", maxPool)
+	span.AddField("mongo.pool.min", minPool)
+
+	clientOptions := options.Client().
+		ApplyURI(uri).
+		SetDirect(true).
+		SetMaxPoolSize(uint64(maxPool)).
+		SetMinPoolSize(uint64(minPool)).
+		SetMaxConnIdleTime(5 * time.Minute)
 
-	clientOptions := options.Client().ApplyURI(uri).SetDirect(true)
 	c, err := mongo.NewClient(clientOptions)
 	if err != nil {
 		span.AddField("mongo.client.error", err)
@@ -32,63 +48,68 @@ func connectDb(ctx context.Context, uri string) (*mongo.Client, error) {
 	err = c.Ping(ctx, nil)
 	if err != nil {
 		span.AddField("mongo.ping.error", err)
+		c.Disconnect(ctx)
 		return nil, err
 	}
 
 	return c, nil
 }
 
-func runQuery(ctx context.Context, mc *mongo.Client, query interface{}) ([]bson.M, error) {
-
-	ctx, span := beeline.StartSpan(ctx, "mongo.runQuery")
-	defer span.Send()
-
-	collection := mc.Database("reminders").Collection("reminders")
-	span.AddField("mongo.runQuery.collection", collection.Name())
-	span.AddField("mongo.runQuery.database", collection.Database().Name())
-	span.AddField("mongo.runQuery.query", query)
-
-	cursor, err := collection.Find(ctx, query)
-	if err != nil {
-		span.AddField("mongo.runQuery.error", err)
-		return nil, err
+// openDatabase opens the backend selected by REMINDER_STORE, which can be mongo, memory
+// or file. Mongo is used by default, connecting to COSMOSDB_URI and the database named by
+// REMINDER_DATABASE.
+func openDatabase(ctx context.Context) (gosmosdb.Database, error) {
+	kind := strings.ToLower(os.Getenv("REMINDER_STORE"))
+
+	switch kind {
+	case "", "mongo":
+		client, err := connectDb(ctx, os.Getenv("COSMOSDB_URI"))
+		if err != nil {
+			return nil, err
+		}
+		return gosmosdb.NewMongoDatabase(client.Database(envOrDefault("REMINDER_DATABASE", "reminders"))), nil
+	case "memory":
+		return gosmosdb.NewMemoryDatabase(), nil
+	case "file":
+		return gosmosdb.OpenFileDatabase(envOrDefault("REMINDER_STORE_PATH", "reminders.json"))
+	default:
+		return nil, fmt.Errorf("unknown reminder store %q, expected mongo, memory or file", kind)
 	}
+}
 
-	var results []bson.M
-	if err = cursor.All(ctx, &results); err != nil {
-		span.AddField("mongo.runQuery.error", err)
-		return nil, err
+// monitorDatabase pings the database on an interval so connection problems show up in
+// traces before they show up as failed commands.
+func monitorDatabase(db gosmosdb.Database, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, span := beeline.StartSpan(ctx, "database.health")
+
+		start := time.Now()
+		err := db.Ping(ctx)
+		span.AddField("database.health.duration_ms", time.Since(start).Milliseconds())
+		span.AddField("database.health.ok", err == nil)
+		if err != nil {
+			span.AddField("database.health.error", err)
+		}
+
+		span.Send()
+		cancel()
 	}
-
-	span.AddField("mongo.runQuery.results.Count", len(results))
-	span.AddField("mongo.runQuery.results.raw", results)
-
-	return results, nil
 }
 
-func writeDbObject(ctx context.Context, mc *mongo.Client, obj interface{}) error {
-
-	ctx, span := beeline.StartSpan(ctx, "mongo.writeObject")
-	defer span.Send()
-
-	data, err := bson.Marshal(obj)
-	if err != nil {
-		span.AddField("mongo.writeObject.error", err)
-		return err
+func envOrDefault(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return def
+}
 
-	collection := mc.Database("reminders").Collection("reminders")
-	span.AddField("mongo.writeObject.collection", collection.Name())
-	span.AddField("mongo.writeObject.database", collection.Database().Name())
-	span.AddField("mongo.writeObject.object", data)
-
-	res, err := collection.InsertOne(ctx, data)
+func envOrDefaultInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
 	if err != nil {
-		span.AddField("mongo.writeObject.error", err)
-		return err
+		return def
 	}
-
-	span.AddField("mongo.writeObject.id", res.InsertedID)
-
-	return nil
+	return v
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+)
+
+// zonedName is a member or timezone name with the timezone it's in
+type zonedName struct {
+	name string
+	loc  *time.Location
+}
+
+var (
+	convertClockPattern = regexp.MustCompile(`(?i)^(?:at\s+)?(` + clockPattern + `)(?:\s+|$)`)
+	convertDayPattern   = regexp.MustCompile(`(?i)^(?:on\s+)?(today|tomorrow|\d{4}-\d{2}-\d{2}|monday|tuesday|wednesday|thursday|friday|saturday|sunday)(?:\s+|$)`)
+	convertToPattern    = regexp.MustCompile(`(?i)(?:^|\s+)to\s+`)
+)
+
+// isTimeConversion reports whether the text given to !time starts with a time of day
+func isTimeConversion(text string) bool {
+	return convertClockPattern.MatchString(strings.TrimSpace(text))
+}
+
+// zoneLookup resolves IANA timezone names like Europe/Berlin itself and passes anything
+// else on to members
+func zoneLookup(members locationLookup) locationLookup {
+	return func(ctx context.Context, name string) (string, *time.Location, error) {
+		// Local would be wherever the bot happens to run
+		if name != "" && name != "Local" {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc.String(), loc, nil
+			}
+		}
+		return members(ctx, name)
+	}
+}
+
+// convertTime converts a time of day where one member or timezone is into the time for
+// others, like "3pm chris" or "15:00 tomorrow Europe/Berlin to US/Pacific". The time is
+// read on its date in the source timezone, so daylight saving is applied as it will be
+// then. Without a source it's the time for self, and without "to" it's converted for
+// everyone returned by others.
+func convertTime(ctx context.Context, text string, now time.Time, self string, lookup locationLookup, others func(ctx context.Context) ([]zonedName, error)) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "convertTime")
+	defer span.Send()
+
+	text = strings.TrimSpace(text)
+	m := convertClockPattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", fmt.Errorf("Start with a time of day, e.g. !time 3pm chris")
+	}
+	hour, minute, err := parseClock(m[1])
+	if err != nil {
+		return "", err
+	}
+	text = text[len(m[0]):]
+
+	day := ""
+	if d := convertDayPattern.FindStringSubmatch(text); d != nil {
+		day = strings.ToLower(d[1])
+		text = text[len(d[0]):]
+	}
+
+	parts := convertToPattern.Split(text, 2)
+	source := strings.Trim(strings.TrimSpace(parts[0]), `"“”`)
+	if source == "" {
+		source = self
+	}
+
+	fromName, from, err := lookup(ctx, source)
+	if err != nil {
+		span.AddField("convertTime.error", err)
+		return "", err
+	}
+	span.AddField("convertTime.from", from.String())
+
+	date, err := conversionDate(now.In(from), day)
+	if err != nil {
+		return "", err
+	}
+	t := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, from)
+
+	var targets []zonedName
+	if len(parts) == 2 {
+		for _, name := range memberNames(parts[1]) {
+			label, loc, err := lookup(ctx, name)
+			if err != nil {
+				span.AddField("convertTime.error", err)
+				return "", err
+			}
+			targets = append(targets, zonedName{label, loc})
+		}
+	} else {
+		everyone, err := others(ctx)
+		if err != nil {
+			span.AddField("convertTime.error", err)
+			return "", err
+		}
+		for _, z := range everyone {
+			if z.name != fromName {
+				targets = append(targets, z)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return "", fmt.Errorf("Nobody else has registered a timezone, say who to convert to, e.g. !time 3pm to Europe/Berlin")
+	}
+	span.AddField("convertTime.targets", len(targets))
+
+	// earliest timezone first
+	sort.SliceStable(targets, func(i, j int) bool {
+		_, a := t.In(targets[i].loc).Zone()
+		_, b := t.In(targets[j].loc).Zone()
+		return a < b
+	})
+
+	var results []string
+	for _, z := range targets {
+		results = append(results, fmt.Sprintf("%s for %s", localClock(t.In(z.loc), t), z.name))
+	}
+
+	return fmt.Sprintf("%02d:%02d on %s for %s is %s, <t:%d:t> for you.", t.Hour(), t.Minute(), t.Format("Monday 2 January"), fromName, joinList(results), t.Unix()), nil
+}
+
+// conversionDate is the day a conversion is for, today where the source is by default
+func conversionDate(now time.Time, day string) (time.Time, error) {
+	switch day {
+	case "", "today":
+		return now, nil
+	case "tomorrow":
+		return now.AddDate(0, 0, 1), nil
+	}
+
+	if weekday, ok := weekdayNames[day]; ok {
+		return now.AddDate(0, 0, (int(weekday)-int(now.Weekday())+7)%7), nil
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", day, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s isn't a date", day)
+	}
+	return date, nil
+}
+
+// localClock shows the time of day of t, with the day if it's not the same day as ref is
+// where ref is
+func localClock(t time.Time, ref time.Time) string {
+	clock := fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
+	if y, m, d := t.Date(); y != ref.Year() || m != ref.Month() || d != ref.Day() {
+		clock += " on " + t.Format("Monday 2 January")
+	}
+	return clock
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConvertTime(t *testing.T) {
+
+	ctx := context.Background()
+
+	zones := map[string]string{
+		"chris":     "Europe/London",
+		"sarah":     "America/New_York",
+		"dave":      "Australia/Perth",
+		"mary rose": "America/New_York",
+	}
+	lookup := zoneLookup(func(ctx context.Context, name string) (string, *time.Location, error) {
+		if name == "<@1001>" {
+			name = "chris"
+		}
+		if zones[name] == "" {
+			return "", nil, fmt.Errorf("User not found")
+		}
+		loc, err := time.LoadLocation(zones[name])
+		return name, loc, err
+	})
+	others := func(ctx context.Context) ([]zonedName, error) {
+		var everyone []zonedName
+		for _, name := range []string{"chris", "dave", "sarah"} {
+			_, loc, _ := lookup(ctx, name)
+			everyone = append(everyone, zonedName{name, loc})
+		}
+		return everyone, nil
+	}
+
+	// New York has started daylight saving by the 15th of March but London hasn't
+	now := time.Date(2026, 3, 15, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"3pm chris", "15:00 on Sunday 15 March for chris is 11:00 for sarah and 23:00 for dave, <t:1773586800:t> for you."},
+		{"17:30", "17:30 on Sunday 15 March for chris is 13:30 for sarah and 01:30 on Monday 16 March for dave, <t:1773595800:t> for you."},
+		{"15:00 Europe/Berlin to US/Pacific", "15:00 on Sunday 15 March for Europe/Berlin is 07:00 for US/Pacific, <t:1773583200:t> for you."},
+		{"9am on 2026-04-01 sarah to chris, dave", "09:00 on Wednesday 1 April for sarah is 14:00 for chris and 21:00 for dave, <t:1775048400:t> for you."},
+		{`3pm to "mary rose" and dave`, "15:00 on Sunday 15 March for chris is 11:00 for mary rose and 23:00 for dave, <t:1773586800:t> for you."},
+		{`9am "mary rose" to chris`, "09:00 on Sunday 15 March for mary rose is 13:00 for chris, <t:1773579600:t> for you."},
+		{"at 10am tomorrow dave to chris", "10:00 on Monday 16 March for dave is 02:00 for chris, <t:1773626400:t> for you."},
+	}
+
+	for _, tc := range tests {
+		resp, err := convertTime(ctx, tc.input, now, "<@1001>", lookup, others)
+		if err != nil {
+			t.Errorf("convertTime %v: FAILED, unexpected error %v", tc.input, err)
+			continue
+		}
+		if resp != tc.expected {
+			t.Errorf("convertTime %v: FAILED, expected %v but got %v", tc.input, tc.expected, resp)
+		}
+	}
+
+	for _, input := range []string{"3pm nobody", "3pm chris to Local", "25:00 chris"} {
+		if _, err := convertTime(ctx, input, now, "<@1001>", lookup, others); err == nil {
+			t.Errorf("convertTime %v: FAILED, expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/honeycombio/beeline-go"
+)
+
+// errNoTimezone is returned for a member who hasn't registered a timezone
+var errNoTimezone = errors.New("no timezone registered")
+
+// locationLookup finds the timezone for a name given to !time, returning how to show the
+// name in replies
+type locationLookup func(ctx context.Context, name string) (string, *time.Location, error)
+
+// memberTimezones finds members' timezones, which they register with !time set and are
+// kept in their settings by user ID. Members who haven't registered one are looked up by
+// name in MEMBER_TIMEZONES. The zone found there is only used, never saved, so a lookup
+// can't bring back the settings of someone who asked to be forgotten.
+type memberTimezones struct {
+	settings UserSettingsStore
+	session  *discordgo.Session
+}
+
+// location returns the user's timezone, or errNoTimezone if they don't have one
+func (z memberTimezones) location(ctx context.Context, guildID string, user *discordgo.User) (*time.Location, error) {
+	ctx, span := beeline.StartSpan(ctx, "memberTimezones.location")
+	defer span.Send()
+
+	span.AddField("memberTimezones.user", user.ID)
+
+	settings, err := z.settings.Get(ctx, user.ID)
+	if err != nil {
+		span.AddField("memberTimezones.error", err)
+		return nil, err
+	}
+	if settings.Timezone != "" {
+		span.AddField("memberTimezones.source", "settings")
+		return time.LoadLocation(settings.Timezone)
+	}
+
+	var names []string
+	if guildID != "" {
+		if member, err := guildMember(ctx, z.session, guildID, user.ID); err == nil {
+			names = append(names, memberDisplayName(member))
+		}
+	}
+	names = append(names, user.Username)
+
+	for _, name := range names {
+		loc, err := memberTimezone(strings.ToLower(name))
+		if err != nil {
+			continue
+		}
+
+		span.AddField("memberTimezones.source", "seed")
+		return loc, nil
+	}
+
+	return nil, errNoTimezone
+}
+
+// seeded finds a member's timezone in MEMBER_TIMEZONES by display name and then username
+func seeded(member *discordgo.Member) (*time.Location, bool) {
+	for _, name := range []string{memberDisplayName(member), member.User.Username} {
+		if loc, err := memberTimezone(strings.ToLower(name)); err == nil {
+			return loc, true
+		}
+	}
+	return nil, false
+}
+
+// lookup resolves a member mention, or a member's nickname or username in the guild, to
+// their timezone
+func (z memberTimezones) lookup(guildID string) locationLookup {
+	return func(ctx context.Context, name string) (string, *time.Location, error) {
+		member, err := z.member(ctx, guildID, name)
+		if err != nil {
+			return "", nil, err
+		}
+
+		label := memberDisplayName(member)
+		loc, err := z.location(ctx, guildID, member.User)
+		if errors.Is(err, errNoTimezone) {
+			return "", nil, fmt.Errorf("%s hasn't registered a timezone, they can with !time set <zone>", label)
+		}
+		return label, loc, err
+	}
+}
+
+// member finds the member mentioned, or with the nickname or username, in the guild
+func (z memberTimezones) member(ctx context.Context, guildID string, name string) (*discordgo.Member, error) {
+	if m := userMentionPattern.FindStringSubmatch(name); m != nil {
+		if guildID == "" {
+			user, err := z.session.User(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("User not found")
+			}
+			return &discordgo.Member{User: user}, nil
+		}
+		member, err := guildMember(ctx, z.session, guildID, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("User not found")
+		}
+		return member, nil
+	}
+
+	if guildID == "" {
+		return nil, fmt.Errorf("Mention someone to get their time in a DM")
+	}
+	found, err := findMembers(ctx, z.session, guildID, name)
+	if err != nil || len(found) == 0 {
+		return nil, fmt.Errorf("User not found")
+	}
+	if len(found) > 1 {
+		return nil, fmt.Errorf("More than one member is called %s, mention the one you mean", name)
+	}
+	return found[0], nil
+}
+
+var (
+	// nameToken matches a quoted name like "mary rose", or a single word or mention
+	nameToken = regexp.MustCompile(`"([^"]+)"|“([^”]+)”|(\S+)`)
+	// nameAnd separates the last names in a list like "sarah, mary rose and dave"
+	nameAnd = regexp.MustCompile(`(?i)(?:^|\s+)and\s+`)
+)
+
+// memberNames splits text into the member and timezone names in it: mentions, quoted
+// names for those with spaces like "mary rose", and otherwise single words. A list with
+// commas is split on them and a final "and" instead, so names there needn't be quoted.
+// Joining words like "and" and "with" are dropped.
+func memberNames(text string) []string {
+	var names []string
+	add := func(name string) {
+		name = strings.Trim(strings.TrimSpace(name), `"“”`)
+		switch strings.ToLower(name) {
+		case "", "and", "with", "for":
+			return
+		}
+		names = append(names, name)
+	}
+
+	if strings.Contains(text, ",") {
+		for _, part := range strings.Split(text, ",") {
+			for _, name := range nameAnd.Split(strings.TrimSpace(part), -1) {
+				add(name)
+			}
+		}
+		return names
+	}
+
+	for _, m := range nameToken.FindAllStringSubmatch(text, -1) {
+		add(m[1] + m[2] + m[3])
+	}
+	return names
+}
+
+// registered returns every member of the guild who has registered a timezone, or who is
+// in MEMBER_TIMEZONES, by their display name. Only members in the gateway state are included, so listing them doesn't
+// make a REST call for every user with a timezone.
+func (z memberTimezones) registered(ctx context.Context, guildID string) ([]zonedName, error) {
+	ctx, span := beeline.StartSpan(ctx, "memberTimezones.registered")
+	defer span.Send()
+
+	if guildID == "" {
+		return nil, nil
+	}
+
+	members := make(map[string]*discordgo.Member)
+	for _, member := range stateMembers(z.session.State, guildID) {
+		members[member.User.ID] = member
+	}
+	span.AddField("memberTimezones.cached", len(members))
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	settings, err := z.settings.ListWithTimezone(ctx)
+	if err != nil {
+		span.AddField("memberTimezones.error", err)
+		return nil, err
+	}
+
+	var zones []zonedName
+	for _, s := range settings {
+		member, ok := members[s.UserID]
+		if !ok {
+			continue
+		}
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			continue
+		}
+		zones = append(zones, zonedName{memberDisplayName(member), loc})
+		delete(members, s.UserID)
+	}
+
+	// members who haven't registered a timezone may still be in MEMBER_TIMEZONES
+	for _, member := range members {
+		if loc, ok := seeded(member); ok {
+			zones = append(zones, zonedName{memberDisplayName(member), loc})
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool { return strings.ToLower(zones[i].name) < strings.ToLower(zones[j].name) })
+
+	span.AddField("memberTimezones.registered", len(zones))
+	return zones, nil
+}
+
+// setTimezone registers the user's timezone, an IANA name like Europe/London
+func setTimezone(ctx context.Context, settings UserSettingsStore, userID string, zone string, now time.Time) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "setTimezone")
+	defer span.Send()
+
+	span.AddField("setTimezone.user", userID)
+	span.AddField("setTimezone.zone", zone)
+
+	if zone == "" {
+		return "", fmt.Errorf("Give a timezone, e.g. !time set Europe/London")
+	}
+
+	// Local would be wherever the bot happens to run
+	loc, err := time.LoadLocation(zone)
+	if err != nil || zone == "Local" {
+		span.AddField("setTimezone.error", err)
+		return "", fmt.Errorf("%s isn't a timezone I know, use a name like Europe/London or America/New_York", zone)
+	}
+
+	saved, err := settings.Get(ctx, userID)
+	if err != nil {
+		span.AddField("setTimezone.error", err)
+		return "", err
+	}
+	saved.Timezone = loc.String()
+	if err := settings.Save(ctx, saved); err != nil {
+		span.AddField("setTimezone.error", err)
+		return "", err
+	}
+
+	local := now.In(loc)
+	return fmt.Sprintf("Your timezone is now %s, where it's %02d:%02d.", loc, local.Hour(), local.Minute()), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+func TestSetTimezone(t *testing.T) {
+
+	ctx := context.Background()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	resp, err := setTimezone(ctx, settings, "1001", "Europe/London", now)
+	if err != nil {
+		t.Fatalf("setTimezone: FAILED, unexpected error %v", err)
+	}
+	if expected := "Your timezone is now Europe/London, where it's 13:00."; resp != expected {
+		t.Errorf("setTimezone: FAILED, expected %v but got %v", expected, resp)
+	}
+	if saved, _ := settings.Get(ctx, "1001"); saved.Timezone != "Europe/London" {
+		t.Errorf("setTimezone: FAILED, expected Europe/London to be saved but got %v", saved.Timezone)
+	}
+
+	for _, zone := range []string{"", "Local", "Europe/Nowhere"} {
+		if _, err := setTimezone(ctx, settings, "1001", zone, now); err == nil {
+			t.Errorf("setTimezone with %q: FAILED, expected an error", zone)
+		}
+	}
+}
+
+func TestMemberTimezones(t *testing.T) {
+
+	os.Setenv("MEMBER_TIMEZONES", "{\"chris\":\"GMT\",\"dave\":\"Australia/Perth\"}")
+	defer os.Unsetenv("MEMBER_TIMEZONES")
+
+	ctx := context.Background()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+	settings.Save(ctx, UserSettings{UserID: "1002", Timezone: "America/New_York"})
+
+	state := discordgo.NewState()
+	err := state.GuildAdd(&discordgo.Guild{
+		ID: "guild",
+		Members: []*discordgo.Member{
+			{GuildID: "guild", User: &discordgo.User{ID: "1001", Username: "chris"}},
+			{GuildID: "guild", User: &discordgo.User{ID: "1002", Username: "sarah"}},
+			{GuildID: "guild", User: &discordgo.User{ID: "1003", Username: "dave"}, Nick: "Sam"},
+			{GuildID: "guild", User: &discordgo.User{ID: "1004", Username: "sam"}},
+			{GuildID: "guild", User: &discordgo.User{ID: "1005", Username: "mary"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GuildAdd: %v", err)
+	}
+
+	// No token is set, so any fallback to the REST API would fail
+	zones := memberTimezones{settings: settings, session: &discordgo.Session{State: state}}
+	lookup := zones.lookup("guild")
+
+	tests := []struct {
+		name     string
+		label    string
+		zone     string
+		hasError bool
+	}{
+		{"<@1002>", "sarah", "America/New_York", false},
+		{"<@!1001>", "chris", "GMT", false},
+		{"sarah", "sarah", "America/New_York", false},
+		{"sam", "", "", true},
+		{"mary", "", "", true},
+	}
+
+	for _, tc := range tests {
+		label, loc, err := lookup(ctx, tc.name)
+		if tc.hasError {
+			if err == nil {
+				t.Errorf("lookup %v: FAILED, expected an error but got %v", tc.name, loc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lookup %v: FAILED, unexpected error %v", tc.name, err)
+			continue
+		}
+		if label != tc.label || loc.String() != tc.zone {
+			t.Errorf("lookup %v: FAILED, expected %v in %v but got %v in %v", tc.name, tc.label, tc.zone, label, loc)
+		}
+	}
+
+	// chris was found in MEMBER_TIMEZONES, which is only read, and dave is found there by
+	// username as his nickname isn't
+	if saved, _ := settings.Get(ctx, "1001"); saved.Timezone != "" {
+		t.Errorf("lookup: FAILED, expected chris's seeded timezone not to be saved but got %v", saved.Timezone)
+	}
+	if _, _, err := lookup(ctx, "<@1003>"); err != nil {
+		t.Errorf("lookup <@1003>: FAILED, expected dave to be found by username but got %v", err)
+	}
+
+	// someone who isn't in the guild's cached members is left out rather than fetched
+	settings.Save(ctx, UserSettings{UserID: "1009", Timezone: "Asia/Tokyo"})
+	registered, err := zones.registered(ctx, "guild")
+	if err != nil {
+		t.Fatalf("registered: FAILED, unexpected error %v", err)
+	}
+	var names []string
+	for _, z := range registered {
+		names = append(names, z.name+" "+z.loc.String())
+	}
+	if expected := "chris GMT, Sam Australia/Perth, sarah America/New_York"; strings.Join(names, ", ") != expected {
+		t.Errorf("registered: FAILED, expected %v but got %v", expected, strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+	"github.com/honeycombio/beeline-go"
+)
+
+// UserSettings are a user's preferences, shared across every server the bot is in
+type UserSettings struct {
+	UserID string `json:"userId" bson:"userId"`
+	// OptOutOthers stops other people setting reminders for the user
+	OptOutOthers bool `json:"optOutOthers" bson:"optOutOthers"`
+	// DefaultTarget is where the user's reminders are sent when they don't choose, with
+	// DefaultTargetChannel the channel for reminderTargetChannel
+	DefaultTarget        string `json:"defaultTarget,omitempty" bson:"defaultTarget,omitempty"`
+	DefaultTargetChannel string `json:"defaultTargetChannel,omitempty" bson:"defaultTargetChannel,omitempty"`
+	// CalendarToken is the secret in the address of the user's calendar feed
+	CalendarToken string `json:"calendarToken,omitempty" bson:"calendarToken,omitempty"`
+	// Timezone is the IANA name of the user's timezone, registered with !time set
+	Timezone string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	// WorkHours is when the user works on weekdays, like 09:00-17:00, set with !time hours
+	WorkHours string `json:"workHours,omitempty" bson:"workHours,omitempty"`
+}
+
+// UserSettingsStore persists UserSettings
+type UserSettingsStore interface {
+	// Get returns the user's settings, or the defaults if they've never changed any
+	Get(ctx context.Context, userID string) (UserSettings, error)
+	// Save stores the user's settings, replacing any saved before. An empty calendar token
+	// leaves the saved one alone, so only RevokeCalendarToken turns the feed off.
+	Save(ctx context.Context, settings UserSettings) error
+	// RevokeCalendarToken removes the user's calendar token, so their feed address stops working
+	RevokeCalendarToken(ctx context.Context, userID string) error
+	// Delete removes the user's settings, reporting false if they had none saved
+	Delete(ctx context.Context, userID string) (bool, error)
+	// FindByCalendarToken returns the settings of the user whose calendar feed has the token
+	FindByCalendarToken(ctx context.Context, token string) (UserSettings, error)
+	// ListWithTimezone returns the settings of every user who has registered a timezone
+	ListWithTimezone(ctx context.Context) ([]UserSettings, error)
+}
+
+// newUserSettingsStore stores settings in USER_SETTINGS_COLLECTION (default usersettings)
+// of the database
+func newUserSettingsStore(db gosmosdb.Database) UserSettingsStore {
+	return &userSettingsRepository{
+		repo: gosmosdb.NewRepository[UserSettings](db.Collection(userSettingsCollection())),
+	}
+}
+
+func userSettingsCollection() string {
+	return envOrDefault("USER_SETTINGS_COLLECTION", "usersettings")
+}
+
+// userSettingsRepository stores settings in a gosmosdb repository
+type userSettingsRepository struct {
+	repo gosmosdb.Repository[UserSettings]
+}
+
+func (s *userSettingsRepository) Get(ctx context.Context, userID string) (UserSettings, error) {
+	settings, err := s.repo.FindOne(ctx, gosmosdb.Where(gosmosdb.Eq("userId", userID)))
+	if errors.Is(err, gosmosdb.ErrNotFound) {
+		return UserSettings{UserID: userID}, nil
+	}
+	return settings, err
+}
+
+func (s *userSettingsRepository) Delete(ctx context.Context, userID string) (bool, error) {
+	n, err := s.repo.Delete(ctx, gosmosdb.Where(gosmosdb.Eq("userId", userID)))
+	return n > 0, err
+}
+
+func (s *userSettingsRepository) FindByCalendarToken(ctx context.Context, token string) (UserSettings, error) {
+	return s.repo.FindOne(ctx, gosmosdb.Where(gosmosdb.Eq("calendarToken", token)))
+}
+
+func (s *userSettingsRepository) RevokeCalendarToken(ctx context.Context, userID string) error {
+	_, err := s.repo.Update(ctx, gosmosdb.Where(gosmosdb.Eq("userId", userID)), gosmosdb.Update{"calendarToken": gosmosdb.Unset})
+	return err
+}
+
+func (s *userSettingsRepository) ListWithTimezone(ctx context.Context) ([]UserSettings, error) {
+	return s.repo.Find(ctx, gosmosdb.Where(gosmosdb.Gt("timezone", "")))
+}
+
+func (s *userSettingsRepository) Save(ctx context.Context, settings UserSettings) error {
+	ctx, span := beeline.StartSpan(ctx, "userSettingsRepository.save")
+	defer span.Send()
+
+	span.AddField("userSettingsRepository.save.user", settings.UserID)
+
+	// one upsert rather than an update then an insert, so two commands saving a new user's
+	// settings at once can't both insert
+	err := s.repo.Upsert(ctx, gosmosdb.Where(gosmosdb.Eq("userId", settings.UserID)), settingsUpdate(settings))
+	if err != nil {
+		span.AddField("userSettingsRepository.save.error", err)
+	}
+	return err
+}
+
+// settingsUpdate lists every setting so saving overwrites the whole document, except the
+// calendar token, which is only written when there is one. A copy of the settings read
+// before the token was made, or a new user's defaults, would otherwise blank it.
+func settingsUpdate(settings UserSettings) gosmosdb.Update {
+	update := gosmosdb.Update{
+		"optOutOthers":         settings.OptOutOthers,
+		"defaultTarget":        settings.DefaultTarget,
+		"defaultTargetChannel": settings.DefaultTargetChannel,
+		"timezone":             settings.Timezone,
+		"workHours":            settings.WorkHours,
+	}
+	if settings.CalendarToken != "" {
+		update["calendarToken"] = settings.CalendarToken
+	}
+	return update
+}
+
+// optedOut returns the users who don't want reminders set by creator, who can always
+// remind themselves
+func optedOut(ctx context.Context, settings UserSettingsStore, creator string, users []string) (map[string]bool, error) {
+	out := make(map[string]bool)
+	if settings == nil {
+		return out, nil
+	}
+
+	for _, user := range users {
+		if user == creator {
+			continue
+		}
+		s, err := settings.Get(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		if s.OptOutOthers {
+			out[user] = true
+		}
+	}
+	return out, nil
+}
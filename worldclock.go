@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeycombio/beeline-go"
+)
+
+// defaultWorkHours is used for members who haven't set their working hours
+const defaultWorkHours = "09:00-17:00"
+
+const (
+	// meetingStep is how far apart the meeting times considered start
+	meetingStep = 30 * time.Minute
+	// meetingSearch is how far ahead to look for meeting times
+	meetingSearch = 14 * 24 * time.Hour
+	// meetingSuggestions is the most meeting times suggested
+	meetingSuggestions = 5
+	// defaultMeetingLength is used when !meet isn't given a length
+	defaultMeetingLength = time.Hour
+)
+
+// workHours is the part of each weekday someone works, in minutes after midnight
+type workHours struct {
+	start, end int
+}
+
+// parseWorkHours reads working hours like 09:00-17:30 or 9am-5pm
+func parseWorkHours(text string) (workHours, error) {
+	parts := strings.Split(text, "-")
+	if len(parts) != 2 {
+		return workHours{}, fmt.Errorf("Give your working hours like 09:00-17:30 or 9am-5pm")
+	}
+
+	startHour, startMinute, err := parseClock(parts[0])
+	if err != nil {
+		return workHours{}, err
+	}
+	endHour, endMinute, err := parseClock(parts[1])
+	if err != nil {
+		return workHours{}, err
+	}
+
+	hours := workHours{startHour*60 + startMinute, endHour*60 + endMinute}
+	if hours.end <= hours.start {
+		return workHours{}, fmt.Errorf("Working hours need to finish after they start on the same day")
+	}
+	return hours, nil
+}
+
+func (h workHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", h.start/60, h.start%60, h.end/60, h.end%60)
+}
+
+// contains reports whether the time from start to end falls within these working hours on
+// a weekday in loc
+func (h workHours) contains(start time.Time, end time.Time, loc *time.Location) bool {
+	from := start.In(loc)
+	to := end.In(loc)
+
+	if from.Weekday() == time.Saturday || from.Weekday() == time.Sunday {
+		return false
+	}
+	if y, m, d := from.Date(); y != to.Year() || m != to.Month() || d != to.Day() {
+		return false
+	}
+	return from.Hour()*60+from.Minute() >= h.start && to.Hour()*60+to.Minute() <= h.end
+}
+
+// setWorkHours saves the user's working hours, or shows them if hours is empty
+func setWorkHours(ctx context.Context, settings UserSettingsStore, userID string, hours string) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "setWorkHours")
+	defer span.Send()
+
+	span.AddField("setWorkHours.user", userID)
+
+	saved, err := settings.Get(ctx, userID)
+	if err != nil {
+		span.AddField("setWorkHours.error", err)
+		return "", err
+	}
+
+	if hours == "" {
+		current := saved.WorkHours
+		if current == "" {
+			current = defaultWorkHours
+		}
+		return fmt.Sprintf("Your working hours are %s on weekdays, change them with !time hours 09:00-17:00", current), nil
+	}
+
+	parsed, err := parseWorkHours(hours)
+	if err != nil {
+		span.AddField("setWorkHours.error", err)
+		return "", err
+	}
+
+	saved.WorkHours = parsed.String()
+	if err := settings.Save(ctx, saved); err != nil {
+		span.AddField("setWorkHours.error", err)
+		return "", err
+	}
+
+	resp := fmt.Sprintf("Your working hours are now %s on weekdays.", parsed)
+	if saved.Timezone == "" {
+		resp += " Set your timezone with !time set <zone> so they can be used."
+	}
+	return resp, nil
+}
+
+// worldClock lists the local time of every registered member, grouped by timezone with the
+// earliest first
+func worldClock(ctx context.Context, members []zonedName, now time.Time) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "worldClock")
+	defer span.Send()
+
+	span.AddField("worldClock.members", len(members))
+
+	if len(members) == 0 {
+		return "", fmt.Errorf("Nobody here has registered a timezone yet, register yours with !time set <zone>")
+	}
+
+	byZone := make(map[string][]string)
+	var zones []*time.Location
+	for _, m := range members {
+		zone := m.loc.String()
+		if _, ok := byZone[zone]; !ok {
+			zones = append(zones, m.loc)
+		}
+		byZone[zone] = append(byZone[zone], m.name)
+	}
+
+	sort.SliceStable(zones, func(i, j int) bool {
+		_, a := now.In(zones[i]).Zone()
+		_, b := now.In(zones[j]).Zone()
+		if a != b {
+			return a < b
+		}
+		return zones[i].String() < zones[j].String()
+	})
+
+	var lines []string
+	for _, loc := range zones {
+		local := now.In(loc)
+		lines = append(lines, fmt.Sprintf("%02d:%02d %s %s: %s", local.Hour(), local.Minute(), local.Format("Mon"), loc, strings.Join(byZone[loc.String()], ", ")))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// attendee is someone !meet is finding a time for
+type attendee struct {
+	name  string
+	loc   *time.Location
+	hours workHours
+}
+
+// attendee looks up a member's timezone and working hours for !meet
+func (z memberTimezones) attendee(ctx context.Context, guildID string, name string) (attendee, error) {
+	member, err := z.member(ctx, guildID, name)
+	if err != nil {
+		return attendee{}, err
+	}
+	label := memberDisplayName(member)
+
+	loc, err := z.location(ctx, guildID, member.User)
+	if errors.Is(err, errNoTimezone) {
+		return attendee{}, fmt.Errorf("%s hasn't registered a timezone, they can with !time set <zone>", label)
+	}
+	if err != nil {
+		return attendee{}, err
+	}
+
+	settings, err := z.settings.Get(ctx, member.User.ID)
+	if err != nil {
+		return attendee{}, err
+	}
+	saved := settings.WorkHours
+	if saved == "" {
+		saved = defaultWorkHours
+	}
+	hours, err := parseWorkHours(saved)
+	if err != nil {
+		return attendee{}, err
+	}
+
+	return attendee{label, loc, hours}, nil
+}
+
+// meetingTimes suggests upcoming times for a meeting of the given length that fall within
+// every attendee's working hours. Suggestions don't overlap, and start on the hour or half
+// hour.
+func meetingTimes(attendees []attendee, length time.Duration, now time.Time) []time.Time {
+	start := now.Truncate(meetingStep)
+	if start.Before(now) {
+		start = start.Add(meetingStep)
+	}
+
+	var times []time.Time
+	for t := start; t.Before(now.Add(meetingSearch)) && len(times) < meetingSuggestions; {
+		fits := true
+		for _, a := range attendees {
+			if !a.hours.contains(t, t.Add(length), a.loc) {
+				fits = false
+				break
+			}
+		}
+
+		if !fits {
+			t = t.Add(meetingStep)
+			continue
+		}
+		times = append(times, t)
+		t = t.Add(length).Add(meetingStep - 1).Truncate(meetingStep)
+	}
+	return times
+}
+
+// planMeeting suggests times for a meeting between the author and the members mentioned or
+// named in text, like `@a "mary rose" 1h`, rendered as Discord timestamps so each reader sees them in their
+// own time
+func planMeeting(ctx context.Context, zones memberTimezones, guildID string, authorID string, text string, now time.Time) (string, error) {
+	ctx, span := beeline.StartSpan(ctx, "planMeeting")
+	defer span.Send()
+
+	length := defaultMeetingLength
+	if durationPattern.MatchString(text) {
+		end, rest, err := parseInterval(ctx, text, now)
+		if err != nil {
+			return "", err
+		}
+		length = end.Sub(now)
+		text = rest
+	}
+	if length > 12*time.Hour {
+		return "", fmt.Errorf("Meetings can be at most 12 hours long")
+	}
+	span.AddField("planMeeting.length", length)
+
+	// members are mentioned or named, with quotes around names that have spaces
+	names := []string{"<@" + authorID + ">"}
+	for _, name := range memberNames(text) {
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 1 {
+		return "", fmt.Errorf("Mention who the meeting is with, e.g. !meet @alice @bob 1h")
+	}
+
+	var attendees []attendee
+	var labels []string
+	for _, name := range names {
+		a, err := zones.attendee(ctx, guildID, name)
+		if err != nil {
+			span.AddField("planMeeting.error", err)
+			return "", err
+		}
+		// the author might also have been named
+		if containsString(labels, a.name) {
+			continue
+		}
+		attendees = append(attendees, a)
+		labels = append(labels, a.name)
+	}
+	if len(attendees) == 1 {
+		return "", fmt.Errorf("Mention who the meeting is with, e.g. !meet @alice @bob 1h")
+	}
+	span.AddField("planMeeting.attendees", len(attendees))
+
+	times := meetingTimes(attendees, length, now)
+	span.AddField("planMeeting.suggestions", len(times))
+	if len(times) == 0 {
+		return "", fmt.Errorf("There's no %s in the next two weeks within everyone's working hours", meetingLength(length))
+	}
+
+	lines := []string{fmt.Sprintf("Times for %s that work for %s:", meetingLength(length), joinList(labels))}
+	for _, t := range times {
+		lines = append(lines, fmt.Sprintf("<t:%d:F> (<t:%d:R>)", t.Unix(), t.Unix()))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// meetingLength shows a meeting length like 1h, 1h30m or 45m
+func meetingLength(d time.Duration) string {
+	s := strings.TrimSuffix(d.String(), "0s")
+	if strings.Contains(s, "h") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/chrislgardner/go-discord-bot/gosmosdb"
+)
+
+func TestParseWorkHours(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+		hasError bool
+	}{
+		{"09:00-17:30", "09:00-17:30", false},
+		{"8am - 4pm", "08:00-16:00", false},
+		{"17:00-09:00", "", true},
+		{"9-5", "", true},
+		{"09:00", "", true},
+	}
+
+	for _, tc := range tests {
+		hours, err := parseWorkHours(tc.input)
+		if tc.hasError {
+			if err == nil {
+				t.Errorf("parseWorkHours %v: FAILED, expected an error but got %v", tc.input, hours)
+			}
+			continue
+		}
+		if err != nil || hours.String() != tc.expected {
+			t.Errorf("parseWorkHours %v: FAILED, expected %v but got %v %v", tc.input, tc.expected, hours, err)
+		}
+	}
+}
+
+func TestWorldClock(t *testing.T) {
+
+	london, _ := time.LoadLocation("Europe/London")
+	newYork, _ := time.LoadLocation("America/New_York")
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	resp, err := worldClock(context.Background(), []zonedName{
+		{"chris", london},
+		{"sarah", newYork},
+		{"ellie", london},
+	}, now)
+	if err != nil {
+		t.Fatalf("worldClock: FAILED, unexpected error %v", err)
+	}
+	if expected := "08:00 Mon America/New_York: sarah\n13:00 Mon Europe/London: chris, ellie"; resp != expected {
+		t.Errorf("worldClock: FAILED, expected %v but got %v", expected, resp)
+	}
+
+	if _, err := worldClock(context.Background(), nil, now); err == nil {
+		t.Errorf("worldClock with nobody: FAILED, expected an error")
+	}
+}
+
+func TestPlanMeeting(t *testing.T) {
+
+	ctx := context.Background()
+	settings := newUserSettingsStore(gosmosdb.NewMemoryDatabase())
+	settings.Save(ctx, UserSettings{UserID: "1001", Timezone: "Europe/London"})
+	settings.Save(ctx, UserSettings{UserID: "1002", Timezone: "America/New_York", WorkHours: "08:00-16:00"})
+	settings.Save(ctx, UserSettings{UserID: "1004", Timezone: "Europe/Paris"})
+
+	state := discordgo.NewState()
+	err := state.GuildAdd(&discordgo.Guild{
+		ID: "guild",
+		Members: []*discordgo.Member{
+			{GuildID: "guild", User: &discordgo.User{ID: "1001", Username: "chris"}},
+			{GuildID: "guild", User: &discordgo.User{ID: "1002", Username: "sarah"}},
+			{GuildID: "guild", User: &discordgo.User{ID: "1003", Username: "mary"}},
+			{GuildID: "guild", User: &discordgo.User{ID: "1004", Username: "mrose"}, Nick: "Mary Rose"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GuildAdd: %v", err)
+	}
+	zones := memberTimezones{settings: settings, session: &discordgo.Session{State: state}}
+
+	// a Friday, with London back on GMT but New York still on daylight saving until Sunday
+	now := time.Date(2026, 10, 30, 15, 10, 0, 0, time.UTC)
+
+	resp, err := planMeeting(ctx, zones, "guild", "1001", "<@1002> 1h", now)
+	if err != nil {
+		t.Fatalf("planMeeting: FAILED, unexpected error %v", err)
+	}
+
+	expected := []string{"Times for 1h that work for chris and sarah:"}
+	for _, due := range []time.Time{
+		time.Date(2026, 10, 30, 15, 30, 0, 0, time.UTC),
+		time.Date(2026, 11, 2, 13, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 2, 14, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 2, 15, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 2, 16, 0, 0, 0, time.UTC),
+	} {
+		expected = append(expected, fmt.Sprintf("<t:%d:F> (<t:%d:R>)", due.Unix(), due.Unix()))
+	}
+	if resp != strings.Join(expected, "\n") {
+		t.Errorf("planMeeting: FAILED, expected %v but got %v", strings.Join(expected, "\n"), resp)
+	}
+
+	// names with spaces are quoted
+	resp, err = planMeeting(ctx, zones, "guild", "1001", `with "mary rose" for 30m`, now)
+	if err != nil {
+		t.Fatalf("planMeeting with a quoted name: FAILED, unexpected error %v", err)
+	}
+	if expected := "Times for 30m that work for chris and Mary Rose:"; !strings.HasPrefix(resp, expected) {
+		t.Errorf("planMeeting with a quoted name: FAILED, expected %v but got %v", expected, resp)
+	}
+
+	for _, text := range []string{"1h", "<@1001> chris 1h", "<@1003> 1h", "<@1002> 13h", "mary rose 1h"} {
+		if _, err := planMeeting(ctx, zones, "guild", "1001", text, now); err == nil {
+			t.Errorf("planMeeting %v: FAILED, expected an error", text)
+		}
+	}
+}
+
+func TestMemberNames(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"<@1001> <@!1002>", []string{"<@1001>", "<@!1002>"}},
+		{`sarah "mary rose" and dave`, []string{"sarah", "mary rose", "dave"}},
+		{"mary rose, Europe/Berlin and dave", []string{"mary rose", "Europe/Berlin", "dave"}},
+		{"mary rose, Europe/Berlin, and dave", []string{"mary rose", "Europe/Berlin", "dave"}},
+		{`with “mary rose”`, []string{"mary rose"}},
+	}
+
+	for _, tc := range tests {
+		if names := memberNames(tc.input); strings.Join(names, "|") != strings.Join(tc.expected, "|") {
+			t.Errorf("memberNames %v: FAILED, expected %v but got %v", tc.input, tc.expected, names)
+		}
+	}
+}